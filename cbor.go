@@ -0,0 +1,380 @@
+package cartsess
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// CBOR tags written by the CBOR serializers. Registered types are tagged
+// cborTagBase plus their registry ID, in the first-come range.
+const (
+	cborTagTimeString = 0
+	cborTagTimeEpoch  = 1
+	cborTagBase       = 55000
+)
+
+var errCBORShort = errors.New("cbor: unexpected end of data")
+
+// CBOREncoder encodes values as CBOR (RFC 8949). See MsgpackEncoder.
+type CBOREncoder struct {
+	Registry *TypeRegistry
+}
+
+// Serialize encodes a value using CBOR.
+func (e CBOREncoder) Serialize(src interface{}) ([]byte, error) {
+	b, err := cborMarshal(e.Registry, serializable(src))
+	if err != nil {
+		return nil, cookieError{cause: err, typ: usageError}
+	}
+	return b, nil
+}
+
+// Deserialize decodes a value using CBOR.
+func (e CBOREncoder) Deserialize(src []byte, dst interface{}) error {
+	v, err := cborUnmarshal(e.Registry, src)
+	if err == nil {
+		err = assignTo(dst, v)
+	}
+	if err != nil {
+		return cookieError{cause: err, typ: decodeError}
+	}
+	return nil
+}
+
+func cborMarshal(registry *TypeRegistry, v interface{}) ([]byte, error) {
+	e := &cborEncoder{registry: registry}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+func cborUnmarshal(registry *TypeRegistry, b []byte) (interface{}, error) {
+	d := &cborDecoder{registry: registry, buf: b}
+	v, err := d.decode()
+	if err == nil && d.pos != len(b) {
+		err = errors.New("cbor: trailing data")
+	}
+	return v, err
+}
+
+type cborEncoder struct {
+	registry *TypeRegistry
+	buf      []byte
+}
+
+// head writes the initial byte of major type major with argument n.
+func (e *cborEncoder) head(major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		e.buf = append(e.buf, major|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, major|24, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, major|26), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, major|27), n)
+	}
+}
+
+func (e *cborEncoder) encodeInt(n int64) {
+	if n >= 0 {
+		e.head(0, uint64(n))
+	} else {
+		e.head(1, uint64(-1-n))
+	}
+}
+
+func (e *cborEncoder) encodeString(s string) {
+	e.head(3, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// encodeTime writes whole seconds as an epoch and anything finer as an
+// RFC 3339 string, which unlike a float epoch keeps every nanosecond.
+func (e *cborEncoder) encodeTime(t time.Time) {
+	if t.Nanosecond() == 0 {
+		e.head(6, cborTagTimeEpoch)
+		e.encodeInt(t.Unix())
+		return
+	}
+	e.head(6, cborTagTimeString)
+	e.encodeString(t.Format(time.RFC3339Nano))
+}
+
+func (e *cborEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() || v.Kind() == reflect.Pointer && v.IsNil() {
+		e.buf = append(e.buf, 0xf6)
+		return nil
+	}
+	if id, ok := e.registry.id(v.Type()); ok {
+		e.head(6, cborTagBase+uint64(id))
+		return e.encodeStruct(reflect.Indirect(v))
+	}
+	if v.Type() == timeType {
+		e.encodeTime(v.Interface().(time.Time))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xf6)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 0xf5)
+		} else {
+			e.buf = append(e.buf, 0xf4)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.head(0, v.Uint())
+	case reflect.Float32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xfa), math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xfb), math.Float64bits(v.Float()))
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			e.buf = append(e.buf, 0xf6)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.head(2, uint64(len(b)))
+			e.buf = append(e.buf, b...)
+			return nil
+		}
+		e.head(4, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xf6)
+			return nil
+		}
+		e.head(5, uint64(v.Len()))
+		for _, k := range sortedMapKeys(v) {
+			if err := e.encode(k); err != nil {
+				return err
+			}
+			if err := e.encode(v.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("cbor: cannot encode %s", v.Type())
+	}
+	return nil
+}
+
+// encodeStruct encodes the exported fields of a struct as a map.
+func (e *cborEncoder) encodeStruct(v reflect.Value) error {
+	names, values := structFields(v)
+	e.head(5, uint64(len(names)))
+	for i, name := range names {
+		e.encodeString(name)
+		if err := e.encode(values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type cborDecoder struct {
+	registry *TypeRegistry
+	buf      []byte
+	pos      int
+}
+
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if uint64(len(d.buf)-d.pos) < n {
+		return nil, errCBORShort
+	}
+	b := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// head reads an initial byte and its argument. For major type 7 the
+// argument of floats is their raw bits.
+func (d *cborDecoder) head() (major byte, info byte, n uint64, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		b, err = d.next(1 << (info - 24))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return major, info, beUint(b), nil
+	}
+	return 0, 0, 0, fmt.Errorf("cbor: unsupported additional information %d", info)
+}
+
+func (d *cborDecoder) decode() (interface{}, error) {
+	major, info, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return intValue(int64(n)), nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, errors.New("cbor: negative integer overflows int64")
+		}
+		return intValue(-1 - int64(n)), nil
+	case 2, 3:
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		if major == 3 {
+			return string(b), nil
+		}
+		return append([]byte(nil), b...), nil
+	case 4:
+		if n > uint64(len(d.buf)-d.pos) {
+			return nil, errCBORShort
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = d.decode(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case 5:
+		return d.mapping(n)
+	case 6:
+		return d.tag(n)
+	}
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return halfToFloat32(uint16(n)), nil
+	case 26:
+		return math.Float32frombits(uint32(n)), nil
+	case 27:
+		return math.Float64frombits(n), nil
+	}
+	return nil, fmt.Errorf("cbor: unsupported simple value %d", n)
+}
+
+func (d *cborDecoder) mapping(n uint64) (interface{}, error) {
+	if 2*n > uint64(len(d.buf)-d.pos) {
+		return nil, errCBORShort
+	}
+	m := make(map[string]interface{}, n)
+	var other map[interface{}]interface{}
+	for i := uint64(0); i < n; i++ {
+		k, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		if ks, ok := k.(string); ok && other == nil {
+			m[ks] = v
+			continue
+		}
+		if other == nil {
+			other = make(map[interface{}]interface{}, n)
+			for ks, v := range m {
+				other[ks] = v
+			}
+		}
+		if k == nil {
+			return nil, errors.New("cbor: nil map key")
+		}
+		if !reflect.TypeOf(k).Comparable() {
+			return nil, fmt.Errorf("cbor: unhashable map key %T", k)
+		}
+		other[k] = v
+	}
+	if other != nil {
+		return other, nil
+	}
+	return m, nil
+}
+
+func (d *cborDecoder) tag(tag uint64) (interface{}, error) {
+	v, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case tag == cborTagTimeString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New("cbor: invalid time string")
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case tag == cborTagTimeEpoch:
+		switch t := v.(type) {
+		case int:
+			return time.Unix(int64(t), 0), nil
+		case int64:
+			return time.Unix(t, 0), nil
+		case float64:
+			sec, frac := math.Modf(t)
+			return time.Unix(int64(sec), int64(frac*1e9)), nil
+		}
+		return nil, errors.New("cbor: invalid epoch time")
+	case tag >= cborTagBase && tag < cborTagBase+128:
+		return d.registry.construct(uint8(tag-cborTagBase), v)
+	}
+	// Unknown tags are ignored, as RFC 8949 allows.
+	return v, nil
+}
+
+// halfToFloat32 converts an IEEE 754 half-precision float.
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+	switch exp {
+	case 0:
+		f := float32(frac) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | frac<<13)
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | frac<<13)
+}
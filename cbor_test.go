@@ -0,0 +1,87 @@
+package cartsess
+
+import (
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCBORUnmarshal_Vectors(t *testing.T) {
+	// RFC 8949 appendix A.
+	for _, tt := range []struct {
+		in   string
+		want interface{}
+	}{
+		{"00", 0},
+		{"17", 23},
+		{"1818", 24},
+		{"1903e8", 1000},
+		{"1b000000e8d4a51000", 1000000000000},
+		{"1bffffffffffffffff", uint64(math.MaxUint64)},
+		{"20", -1},
+		{"3903e7", -1000},
+		{"f93c00", float32(1)},
+		{"f9c400", float32(-4)},
+		{"fa47c35000", float32(100000)},
+		{"fb3ff199999999999a", 1.1},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6449455446", "IETF"},
+		{"83010203", []interface{}{1, 2, 3}},
+		{"a26161016162820203", map[string]interface{}{"a": 1, "b": []interface{}{2, 3}}},
+		{"a201020304", map[interface{}]interface{}{1: 2, 3: 4}},
+		{"d74401020304", []byte{1, 2, 3, 4}}, // unknown tag 23 is ignored
+	} {
+		b, _ := hex.DecodeString(tt.in)
+		got, err := cborUnmarshal(nil, b)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %#v, %v, want %#v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestCBORUnmarshal_Times(t *testing.T) {
+	want := time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)
+	for _, in := range []string{
+		"c074323031332d30332d32315432303a30343a30305a", // tag 0, RFC 3339
+		"c11a514b67b0", // tag 1, epoch seconds
+	} {
+		b, _ := hex.DecodeString(in)
+		got, err := cborUnmarshal(nil, b)
+		if tm, ok := got.(time.Time); err != nil || !ok || !tm.Equal(want) {
+			t.Errorf("%s: got %#v, %v", in, got, err)
+		}
+	}
+
+	// Times round-trip with their nanoseconds.
+	now := time.Now()
+	b, err := cborMarshal(nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cborUnmarshal(nil, b); err != nil || !got.(time.Time).Equal(now) {
+		t.Errorf("expected %v, got %v, %v", now, got, err)
+	}
+}
+
+func TestCBORUnmarshal_Invalid(t *testing.T) {
+	for name, in := range map[string]string{
+		"nil key":     "a1f601",   // {null: 1}
+		"array key":   "a1810101", // {[1]: 1}
+		"short map":   "a2616101", // two entries, one present
+		"short bytes": "4401",     // 4 bytes announced, 1 present
+		"indefinite":  "9f01ff",   // indefinite-length array
+		"trailing":    "0101",     // two items
+		"overflow":    "3bffffffffffffffff",
+		"time tag":    "c001", // tag 0 on an integer
+	} {
+		b, _ := hex.DecodeString(in)
+		if _, err := cborUnmarshal(nil, b); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
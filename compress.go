@@ -0,0 +1,216 @@
+package cartsess
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compressor compresses serialized session payloads.
+type Compressor interface {
+	// ID identifies the algorithm in the payload header. It must be in the
+	// range 1-15; 1 and 2 are taken by GzipCompressor and FlateCompressor.
+	ID() byte
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+}
+
+// compressedMarker is or'ed with the Compressor ID into the header byte
+// of compressed payloads. Payloads left uncompressed get the header
+// uncompressedMarker instead, so every payload says what it is whatever
+// the wrapped serializer writes.
+//
+// Payloads without either header were written before compression was
+// enabled and are returned as-is: neither gob nor JSON output starts with
+// 0x00 or 0xC1-0xCF.
+const (
+	compressedMarker   = 0xC0
+	uncompressedMarker = 0x00
+)
+
+// maxDecompressedSize bounds the size of a decompressed payload.
+const maxDecompressedSize = 16 << 20
+
+var (
+	errDecompressedTooLarge = errors.New("decompressed payload too large")
+	errCompressorID         = errors.New("compressor id must be in the range 1-15")
+)
+
+var (
+	compressorsMutex sync.RWMutex
+	compressors      = map[byte]Compressor{
+		GzipCompressor{}.ID():  GzipCompressor{},
+		FlateCompressor{}.ID(): FlateCompressor{},
+	}
+)
+
+// RegisterCompressor makes payloads compressed by c decodable by every
+// CompressingSerializer, whichever Compressor it is configured with. It
+// fails if the ID of c is out of range or already registered.
+func RegisterCompressor(c Compressor) error {
+	id := c.ID()
+	if id < 1 || id > 15 {
+		return errCompressorID
+	}
+	compressorsMutex.Lock()
+	defer compressorsMutex.Unlock()
+	if _, ok := compressors[id]; ok {
+		return fmt.Errorf("compressor %d is already registered", id)
+	}
+	compressors[id] = c
+	return nil
+}
+
+// GzipCompressor compresses with compress/gzip.
+type GzipCompressor struct {
+	Level int // compression level, 0 for gzip.DefaultCompression
+}
+
+func (c GzipCompressor) ID() byte { return 1 }
+
+func (c GzipCompressor) Compress(b []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	buf := new(bytes.Buffer)
+	w, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(b); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c GzipCompressor) Decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r)
+}
+
+// FlateCompressor compresses with compress/flate. It has less framing
+// overhead than gzip, which matters for cookies.
+type FlateCompressor struct {
+	Level int // compression level, 0 for flate.DefaultCompression
+}
+
+func (c FlateCompressor) ID() byte { return 2 }
+
+func (c FlateCompressor) Compress(b []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	buf := new(bytes.Buffer)
+	w, err := flate.NewWriter(buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(b); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c FlateCompressor) Decompress(b []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+	return readLimited(r)
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxDecompressedSize {
+		return nil, errDecompressedTooLarge
+	}
+	return b, nil
+}
+
+// compressPayload compresses b with c when it is at least threshold bytes
+// long and compression makes it smaller, and prepends the header.
+func compressPayload(c Compressor, threshold int, b []byte) ([]byte, error) {
+	id := c.ID()
+	if id < 1 || id > 15 {
+		return nil, errCompressorID
+	}
+	if len(b) >= threshold {
+		compressed, err := c.Compress(b)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(b) {
+			return append([]byte{compressedMarker | id}, compressed...), nil
+		}
+	}
+	return append([]byte{uncompressedMarker}, b...), nil
+}
+
+// decompressPayload reverses compressPayload. Payloads compressed by a
+// registered compressor are understood whatever c is, so the algorithm
+// can be changed without breaking stored sessions.
+func decompressPayload(c Compressor, b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return b, nil
+	}
+	if b[0] == uncompressedMarker {
+		return b[1:], nil
+	}
+	if b[0]&0xF0 != compressedMarker || b[0] == compressedMarker {
+		return b, nil
+	}
+	id := b[0] &^ compressedMarker
+	if c == nil || c.ID() != id {
+		compressorsMutex.RLock()
+		c = compressors[id]
+		compressorsMutex.RUnlock()
+		if c == nil {
+			return nil, fmt.Errorf("unknown compressor %d", id)
+		}
+	}
+	return c.Decompress(b[1:])
+}
+
+// CompressingSerializer wraps a Serializer, compressing payloads of at
+// least Threshold bytes.
+type CompressingSerializer struct {
+	Serializer Serializer
+	Compressor Compressor
+	Threshold  int
+}
+
+func (s CompressingSerializer) Serialize(src interface{}) ([]byte, error) {
+	b, err := s.Serializer.Serialize(src)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = compressPayload(s.Compressor, s.Threshold, b); err != nil {
+		return nil, cookieError{cause: err, typ: usageError}
+	}
+	return b, nil
+}
+
+func (s CompressingSerializer) Deserialize(src []byte, dst interface{}) error {
+	b, err := decompressPayload(s.Compressor, src)
+	if err != nil {
+		return cookieError{cause: err, typ: decodeError}
+	}
+	return s.Serializer.Deserialize(b, dst)
+}
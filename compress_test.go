@@ -0,0 +1,111 @@
+package cartsess
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompressingSessionSerializer(t *testing.T) {
+	sz := CompressingSessionSerializer{
+		Serializer: GobSerializer{},
+		Compressor: FlateCompressor{},
+		Threshold:  256,
+	}
+	session := NewSession(nil, "sess")
+	session.Values["cart"] = strings.Repeat("apple,", 500)
+
+	plain, _ := GobSerializer{}.Serialize(session)
+	b, err := sz.Serialize(session)
+	if err != nil {
+		t.Fatalf("failed to serialize: %v", err)
+	}
+	if len(b) >= len(plain) || b[0] != compressedMarker|2 {
+		t.Errorf("expected compressed payload, got %d bytes (plain %d)", len(b), len(plain))
+	}
+
+	loaded := NewSession(nil, "sess")
+	if err := sz.Deserialize(b, loaded); err != nil {
+		t.Fatalf("failed to deserialize: %v", err)
+	}
+	if loaded.Values["cart"] != session.Values["cart"] {
+		t.Error("expected values to round-trip")
+	}
+
+	// Uncompressed payloads, e.g. written before compression was enabled,
+	// still decode.
+	legacy := NewSession(nil, "sess")
+	if err := sz.Deserialize(plain, legacy); err != nil {
+		t.Fatalf("failed to deserialize uncompressed payload: %v", err)
+	}
+	if legacy.Values["cart"] != session.Values["cart"] {
+		t.Error("expected uncompressed values to round-trip")
+	}
+}
+
+func TestCompressingSerializer_Cookie(t *testing.T) {
+	s := New(GenerateRandomKey(32), nil)
+	s.SetSerializer(CompressingSerializer{
+		Serializer: JSONEncoder{},
+		Compressor: GzipCompressor{},
+		Threshold:  64,
+	})
+	value := map[string]interface{}{"cart": strings.Repeat("pear,", 2000)}
+
+	encoded, err := s.Encode("sess", value)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	dst := make(map[string]interface{})
+	if err := s.Decode("sess", encoded, &dst); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if dst["cart"] != value["cart"] {
+		t.Error("expected values to round-trip")
+	}
+}
+
+// testCompressor is a Compressor with a configurable ID.
+type testCompressor struct {
+	FlateCompressor
+	id byte
+}
+
+func (c testCompressor) ID() byte { return c.id }
+
+func TestCompressPayload_Headers(t *testing.T) {
+	// Short payloads are marked as uncompressed, even when they start
+	// like a compressed one.
+	raw := []byte{compressedMarker | 2, 'x'}
+	b, err := compressPayload(FlateCompressor{}, 64, raw)
+	if err != nil || b[0] != uncompressedMarker {
+		t.Fatalf("expected an uncompressed header, got %v, %v", b, err)
+	}
+	if got, err := decompressPayload(FlateCompressor{}, b); err != nil || string(got) != string(raw) {
+		t.Errorf("expected the raw payload back, got %v, %v", got, err)
+	}
+
+	for _, id := range []byte{0, 16} {
+		if _, err := compressPayload(testCompressor{id: id}, 0, raw); err != errCompressorID {
+			t.Errorf("expected id %d to be rejected, got %v", id, err)
+		}
+		if err := RegisterCompressor(testCompressor{id: id}); err != errCompressorID {
+			t.Errorf("expected registering id %d to fail, got %v", id, err)
+		}
+	}
+	if err := RegisterCompressor(testCompressor{id: 2}); err == nil {
+		t.Error("expected a taken id to be rejected")
+	}
+
+	// Payloads of a registered compressor decode with any other one.
+	if err := RegisterCompressor(testCompressor{id: 9}); err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	long := []byte(strings.Repeat("plum,", 100))
+	b, _ = compressPayload(testCompressor{id: 9}, 0, long)
+	if b[0] != compressedMarker|9 {
+		t.Fatalf("expected a compressed header, got %x", b[0])
+	}
+	if got, err := decompressPayload(GzipCompressor{}, b); err != nil || string(got) != string(long) {
+		t.Errorf("expected the registered compressor to decode, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package cartsess
+
+import (
+	"errors"
+)
+
+var errSessionIDNotSet = errors.New("session id is not set")
+
+// EncryptingSerializer wraps a Serializer and encrypts its output,
+// so server-side stores such as RedisStore and MemoryStore never hold
+// session values in plaintext. It only serializes sessions, not cookie
+// values.
+//
+// Codec does the encryption; use NewStoreAEAD codecs, usually in a
+// KeyRing for key rotation. The session ID is used as the codec name, so
+// a payload copied to another session's key fails to decrypt.
+type EncryptingSerializer struct {
+	Serializer Serializer
+	Codec      Codec
+}
+
+var _ Serializer = EncryptingSerializer{}
+
+// NewEncryptingSerializer wraps sz with encryption by the keys of ring.
+func NewEncryptingSerializer(sz Serializer, ring *KeyRing) EncryptingSerializer {
+	return EncryptingSerializer{Serializer: sz, Codec: ring}
+}
+
+// NewStoreAEAD returns an AES-GCM codec for EncryptingSerializer. Unlike
+// cookie codecs it has no maximum age or length: stores expire sessions
+// themselves.
+func NewStoreAEAD(key []byte) *SecureCookie {
+	return NewAEAD(key).SetSerializer(NopEncoder{}).MaxAge(0).MaxLength(0)
+}
+
+func (s EncryptingSerializer) Serialize(src interface{}) ([]byte, error) {
+	session, ok := src.(*Session)
+	if !ok {
+		return nil, errNotSession
+	}
+	if session.ID == "" {
+		return nil, errSessionIDNotSet
+	}
+	b, err := s.Serializer.Serialize(session)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := s.Codec.Encode(session.ID, b)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(encoded), nil
+}
+
+func (s EncryptingSerializer) Deserialize(d []byte, dst interface{}) error {
+	session, ok := dst.(*Session)
+	if !ok {
+		return errNotSession
+	}
+	if session.ID == "" {
+		return errSessionIDNotSet
+	}
+	var b []byte
+	if err := s.Codec.Decode(session.ID, string(d), &b); err != nil {
+		return err
+	}
+	return s.Serializer.Deserialize(b, session)
+}
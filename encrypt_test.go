@@ -0,0 +1,68 @@
+package cartsess
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEncryptingSerializer(t *testing.T) {
+	ring := NewKeyRing()
+	ring.Add("k1", NewStoreAEAD(GenerateRandomKey(32)))
+	sz := NewEncryptingSerializer(GobSerializer{}, ring)
+
+	session := NewSession(nil, "sess")
+	session.ID = "session-a"
+	session.Values["email"] = "alice@example.com"
+	b, err := sz.Serialize(session)
+	if err != nil {
+		t.Fatalf("failed to serialize: %v", err)
+	}
+	if bytes.Contains(b, []byte("alice")) {
+		t.Error("expected payload to be encrypted")
+	}
+
+	ring.Rotate("k2", NewStoreAEAD(GenerateRandomKey(32)), 0)
+	loaded := NewSession(nil, "sess")
+	loaded.ID = "session-a"
+	if err := sz.Deserialize(b, loaded); err != nil {
+		t.Fatalf("expected payload from the previous key to decrypt: %v", err)
+	}
+	if loaded.Values["email"] != "alice@example.com" {
+		t.Errorf("expected email to round-trip, got %v", loaded.Values["email"])
+	}
+
+	other := NewSession(nil, "sess")
+	other.ID = "session-b"
+	if err := sz.Deserialize(b, other); err == nil {
+		t.Error("expected payload bound to another session ID to be rejected")
+	}
+}
+
+func TestMemoryStore_Serializer(t *testing.T) {
+	ring := NewKeyRing()
+	ring.Add("k1", NewStoreAEAD(GenerateRandomKey(32)))
+	store := NewMemoryStore()
+	store.Serializer = NewEncryptingSerializer(GobSerializer{}, ring)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "sess")
+	session.Values["user"] = "alice"
+	if err := session.Save(req, rec); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if _, ok := store.value[session.ID].([]byte); !ok {
+		t.Error("expected session to be stored serialized")
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(rec.Result().Cookies()[0])
+	loaded, err := store.Get(req, "sess")
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	if loaded.Values["user"] != "alice" {
+		t.Errorf("expected user 'alice', got %v", loaded.Values["user"])
+	}
+}
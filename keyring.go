@@ -0,0 +1,303 @@
+package cartsess
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyState is the lifecycle state of a key in a KeyRing.
+type KeyState int
+
+const (
+	// KeyActive keys may encode values. The active key with the latest
+	// ActiveFrom that has been reached is the one used.
+	KeyActive KeyState = iota
+	// KeyDecryptOnly keys only decode values issued earlier.
+	KeyDecryptOnly
+	// KeyRetired keys are kept for reference but reject every value.
+	KeyRetired
+)
+
+func (s KeyState) String() string {
+	switch s {
+	case KeyActive:
+		return "active"
+	case KeyDecryptOnly:
+		return "decrypt-only"
+	case KeyRetired:
+		return "retired"
+	}
+	return "unknown"
+}
+
+// kidSeparator separates the key ID from the value encoded by the key's
+// codec. It is outside the base64 URL alphabet, so untagged values never
+// contain it.
+const kidSeparator = ":"
+
+var (
+	errKeyIDInvalid = cookieError{typ: usageError, msg: "invalid key id"}
+	errNoActiveKey  = cookieError{typ: usageError, msg: "no active key"}
+	errKeyUnknown   = cookieError{typ: decodeError, msg: "unknown key id"}
+	errKeyRetired   = cookieError{typ: decodeError, msg: "key is retired"}
+)
+
+// RingKey is a codec registered in a KeyRing under an ID.
+type RingKey struct {
+	ID         string
+	Codec      Codec
+	State      KeyState
+	ActiveFrom time.Time // the key is not used to encode before this time
+	RetireAt   time.Time // the key is retired from this time on (zero: never)
+}
+
+// state returns the effective state of the key at t.
+func (k *RingKey) state(t time.Time) KeyState {
+	if k.State == KeyRetired || (!k.RetireAt.IsZero() && !t.Before(k.RetireAt)) {
+		return KeyRetired
+	}
+	if k.State == KeyActive && !t.Before(k.ActiveFrom) {
+		return KeyActive
+	}
+	return KeyDecryptOnly
+}
+
+// KeyRing is a Codec that tags every value with the ID of the key that
+// encoded it, so decoding goes straight to that key instead of trying
+// every codec in turn as DecodeMulti does.
+//
+// Values are encoded as "id:value". Untagged values, issued before the
+// ring was introduced, are decoded by trying the non-retired keys in order.
+//
+// MaxAge, MaxLength and SetSerializer apply to the SecureCookie codecs in
+// the ring and to those added later.
+type KeyRing struct {
+	mutex sync.RWMutex
+	keys  []*RingKey
+	// Settings of the SecureCookie codecs, applied by configure.
+	maxAge       int
+	maxAgeSet    bool
+	maxLength    int
+	maxLengthSet bool
+	serializer   Serializer
+	// For testing purposes, the function that returns the current time.
+	timeFunc func() time.Time
+}
+
+var _ Codec = &KeyRing{}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{}
+}
+
+func (k *KeyRing) now() time.Time {
+	if k.timeFunc == nil {
+		return time.Now()
+	}
+	return k.timeFunc()
+}
+
+// Add registers codec under id as an active key.
+func (k *KeyRing) Add(id string, codec Codec) error {
+	return k.add(&RingKey{ID: id, Codec: codec, State: KeyActive})
+}
+
+// Schedule registers codec under id to become the active key at t. Until
+// then it only decodes.
+func (k *KeyRing) Schedule(id string, codec Codec, t time.Time) error {
+	return k.add(&RingKey{ID: id, Codec: codec, State: KeyActive, ActiveFrom: t})
+}
+
+// Rotate makes codec, registered under id, the active key right away. The
+// keys registered before become decrypt-only and, if grace is positive,
+// retire after grace.
+func (k *KeyRing) Rotate(id string, codec Codec, grace time.Duration) error {
+	if err := k.Add(id, codec); err != nil {
+		return err
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	now := k.now()
+	for _, key := range k.keys {
+		if key.ID == id {
+			continue
+		}
+		if key.State == KeyActive {
+			key.State = KeyDecryptOnly
+		}
+		if grace > 0 && key.RetireAt.IsZero() {
+			key.RetireAt = now.Add(grace)
+		}
+	}
+	return nil
+}
+
+// SetState changes the state of the key registered under id.
+func (k *KeyRing) SetState(id string, state KeyState) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	for _, key := range k.keys {
+		if key.ID == id {
+			key.State = state
+			return nil
+		}
+	}
+	return errKeyUnknown
+}
+
+// Keys returns a copy of the registered keys, in registration order.
+func (k *KeyRing) Keys() []RingKey {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	keys := make([]RingKey, len(k.keys))
+	for i, key := range k.keys {
+		keys[i] = *key
+	}
+	return keys
+}
+
+func (k *KeyRing) add(key *RingKey) error {
+	if key.ID == "" || strings.ContainsAny(key.ID, kidSeparator+chunkMarker+"\";\\ ") {
+		return errKeyIDInvalid
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	for _, existing := range k.keys {
+		if existing.ID == key.ID {
+			return cookieError{typ: usageError, msg: fmt.Sprintf("duplicate key id %q", key.ID)}
+		}
+	}
+	k.configure(key.Codec)
+	k.keys = append(k.keys, key)
+	return nil
+}
+
+// configure applies the settings of the ring to codec. The mutex must be
+// held.
+func (k *KeyRing) configure(codec Codec) {
+	sc, ok := codec.(*SecureCookie)
+	if !ok {
+		return
+	}
+	if k.maxAgeSet {
+		sc.MaxAge(k.maxAge)
+	}
+	if k.maxLengthSet {
+		sc.MaxLength(k.maxLength)
+	}
+	if k.serializer != nil {
+		sc.SetSerializer(k.serializer)
+	}
+}
+
+// active returns the key used to encode at t.
+func (k *KeyRing) active(t time.Time) *RingKey {
+	var active *RingKey
+	for _, key := range k.keys {
+		if key.state(t) == KeyActive && (active == nil || !key.ActiveFrom.Before(active.ActiveFrom)) {
+			active = key
+		}
+	}
+	return active
+}
+
+// ActiveID returns the ID of the key currently used to encode.
+func (k *KeyRing) ActiveID() string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	if key := k.active(k.now()); key != nil {
+		return key.ID
+	}
+	return ""
+}
+
+// Encode encodes value with the active key and tags it with the key's ID.
+func (k *KeyRing) Encode(name string, value interface{}) (string, error) {
+	k.mutex.RLock()
+	key := k.active(k.now())
+	k.mutex.RUnlock()
+	if key == nil {
+		return "", errNoActiveKey
+	}
+	encoded, err := key.Codec.Encode(name, value)
+	if err != nil {
+		return "", err
+	}
+	return key.ID + kidSeparator + encoded, nil
+}
+
+// Decode decodes a value encoded by any non-retired key.
+func (k *KeyRing) Decode(name, value string, dst interface{}) error {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	now := k.now()
+	id, encoded, tagged := strings.Cut(value, kidSeparator)
+	if !tagged {
+		var errors MultiError
+		for _, key := range k.keys {
+			if key.state(now) == KeyRetired {
+				continue
+			}
+			err := key.Codec.Decode(name, value, dst)
+			if err == nil {
+				return nil
+			}
+			errors = append(errors, err)
+		}
+		if len(errors) == 0 {
+			return errKeyUnknown
+		}
+		return errors
+	}
+	for _, key := range k.keys {
+		if key.ID != id {
+			continue
+		}
+		if key.state(now) == KeyRetired {
+			return errKeyRetired
+		}
+		return key.Codec.Decode(name, encoded, dst)
+	}
+	return errKeyUnknown
+}
+
+// IsStale reports whether value was not encoded by the active key, and
+// should be re-encoded.
+func (k *KeyRing) IsStale(value string) bool {
+	id, _, tagged := strings.Cut(value, kidSeparator)
+	return !tagged || id != k.ActiveID()
+}
+
+// MaxAge sets the maximum age of every SecureCookie codec in the ring.
+func (k *KeyRing) MaxAge(age int) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.maxAge, k.maxAgeSet = age, true
+	k.configureAll()
+}
+
+// MaxLength sets the maximum length of every SecureCookie codec in the ring.
+func (k *KeyRing) MaxLength(length int) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.maxLength, k.maxLengthSet = length, true
+	k.configureAll()
+}
+
+// SetSerializer sets the serializer of every SecureCookie codec in the ring.
+func (k *KeyRing) SetSerializer(sz Serializer) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.serializer = sz
+	k.configureAll()
+}
+
+// configureAll applies the settings of the ring to every key. The mutex
+// must be held.
+func (k *KeyRing) configureAll() {
+	for _, key := range k.keys {
+		k.configure(key.Codec)
+	}
+}
@@ -0,0 +1,109 @@
+package cartsess
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyRing_Rotation(t *testing.T) {
+	ring := NewKeyRing()
+	ring.Add("k1", NewAEAD(GenerateRandomKey(32)))
+
+	old, err := ring.Encode("sess", map[string]interface{}{"n": 1})
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	if !strings.HasPrefix(old, "k1:") {
+		t.Errorf("expected value tagged with k1, got %q", old)
+	}
+
+	ring.Rotate("k2", NewAEAD(GenerateRandomKey(32)), time.Hour)
+	if ring.ActiveID() != "k2" {
+		t.Errorf("expected k2 to be active, got %q", ring.ActiveID())
+	}
+	if !ring.IsStale(old) {
+		t.Error("expected value encoded by k1 to be stale")
+	}
+	dst := make(map[string]interface{})
+	if err := ring.Decode("sess", old, &dst); err != nil {
+		t.Fatalf("expected decrypt-only key to decode: %v", err)
+	}
+
+	ring.timeFunc = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if err := ring.Decode("sess", old, &dst); err != errKeyRetired {
+		t.Errorf("expected errKeyRetired after the grace period, got %v", err)
+	}
+}
+
+func TestKeyRing_Schedule(t *testing.T) {
+	ring := NewKeyRing()
+	ring.Add("k1", NewAEAD(GenerateRandomKey(32)))
+	at := time.Now().Add(time.Hour)
+	ring.Schedule("k2", NewAEAD(GenerateRandomKey(32)), at)
+
+	if ring.ActiveID() != "k1" {
+		t.Errorf("expected k1 before the scheduled time, got %q", ring.ActiveID())
+	}
+	ring.timeFunc = func() time.Time { return at }
+	if ring.ActiveID() != "k2" {
+		t.Errorf("expected k2 at the scheduled time, got %q", ring.ActiveID())
+	}
+}
+
+func TestKeyRing_SettingsApplyToRotatedKeys(t *testing.T) {
+	ring := NewKeyRing()
+	ring.Add("k1", NewAEAD(GenerateRandomKey(32)))
+	store := NewCookieStoreWithKeyRing(ring)
+	store.MaxChunks(4)
+	store.MaxAge(3600)
+	store.SetSerializer(JSONEncoder{})
+
+	ring.Rotate("k2", NewAEAD(GenerateRandomKey(32)), time.Hour)
+
+	sc := ring.Keys()[1].Codec.(*SecureCookie)
+	if sc.maxAge != 3600 {
+		t.Errorf("expected max age 3600 on the rotated key, got %d", sc.maxAge)
+	}
+	if _, ok := sc.sz.(JSONEncoder); !ok {
+		t.Errorf("expected JSONEncoder on the rotated key, got %T", sc.sz)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "sess")
+	session.Values["cart"] = strings.Repeat("x", 6000)
+	if err := session.Save(req, rec); err != nil {
+		t.Fatalf("failed to save large session after rotation: %v", err)
+	}
+}
+
+func TestCookieStore_ReencodesStaleKey(t *testing.T) {
+	ring := NewKeyRing()
+	ring.Add("k1", New(GenerateRandomKey(32), nil))
+	store := NewCookieStoreWithKeyRing(ring)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "sess")
+	session.Values["user"] = "alice"
+	session.Save(req, rec)
+	cookie := rec.Result().Cookies()[0]
+
+	ring.Rotate("k2", New(GenerateRandomKey(32), nil), 0)
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	manager := &SessionManager{cookieName: "sess", store: store, request: req, response: rec}
+	manager.Get("user")
+	if err := manager.Save(); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || !strings.HasPrefix(cookies[0].Value, "k2:") {
+		t.Fatalf("expected cookie re-encoded with k2, got %v", cookies)
+	}
+}
@@ -0,0 +1,160 @@
+package cartsess
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Purposes of the subkeys derived by Keys. The purpose is part of the HKDF
+// info, so a key derived for one purpose is useless for any other.
+const (
+	PurposeCookieHash    = "cartsess cookie hash"
+	PurposeCookieBlock   = "cartsess cookie block"
+	PurposeJWTSigning    = "cartsess jwt signing"
+	PurposeJWTEncryption = "cartsess jwt encryption"
+	PurposeIDSigning     = "cartsess id signing"
+)
+
+type masterSecret struct {
+	version string
+	secret  []byte
+}
+
+// Keys derives purpose-bound subkeys from a master secret with
+// HKDF-SHA256, so one secret can configure every store.
+//
+// Masters are versioned for rotation: the most recently added is current
+// and derives the keys used to sign and encrypt, while older versions
+// still derive keys to verify and decrypt what they issued.
+type Keys struct {
+	mutex   sync.RWMutex
+	masters []masterSecret
+}
+
+// NewKeys creates a Keys with master as version "1".
+func NewKeys(master []byte) (*Keys, error) {
+	k := &Keys{}
+	if err := k.AddMaster("1", master); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// AddMaster adds a master secret under version and makes it current.
+func (k *Keys) AddMaster(version string, master []byte) error {
+	if len(master) < 32 {
+		return errors.New("master secret must be at least 32 bytes")
+	}
+	if version == "" {
+		return errors.New("master version must not be empty")
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	for _, m := range k.masters {
+		if m.version == version {
+			return errors.New("duplicate master version " + version)
+		}
+	}
+	k.masters = append(k.masters, masterSecret{version: version, secret: master})
+	return nil
+}
+
+// Versions returns the master versions, current first.
+func (k *Keys) Versions() []string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	versions := make([]string, len(k.masters))
+	for i, m := range k.masters {
+		versions[len(k.masters)-1-i] = m.version
+	}
+	return versions
+}
+
+// Current returns the version of the current master.
+func (k *Keys) Current() string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.masters[len(k.masters)-1].version
+}
+
+// Derive returns a length-byte subkey for purpose from the master with
+// the given version, or nil if there is no such version or length exceeds
+// what HKDF-SHA256 can derive (8160 bytes).
+func (k *Keys) Derive(version, purpose string, length int) []byte {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	for _, m := range k.masters {
+		if m.version == version {
+			key := make([]byte, length)
+			r := hkdf.New(sha256.New, m.secret, nil, []byte(purpose+"|"+version))
+			if _, err := io.ReadFull(r, key); err != nil {
+				return nil
+			}
+			return key
+		}
+	}
+	return nil
+}
+
+// CookieHashKey returns the SecureCookie hash key of a master version.
+func (k *Keys) CookieHashKey(version string) []byte {
+	return k.Derive(version, PurposeCookieHash, 32)
+}
+
+// CookieBlockKey returns the SecureCookie block key (AES-256) of a master
+// version.
+func (k *Keys) CookieBlockKey(version string) []byte {
+	return k.Derive(version, PurposeCookieBlock, 32)
+}
+
+// JWTSigningKey returns the JWTStore HMAC signing key of a master version.
+func (k *Keys) JWTSigningKey(version string) []byte {
+	return k.Derive(version, PurposeJWTSigning, 32)
+}
+
+// JWTEncryptionKey returns the JWTStore A256GCM encryption key of a master
+// version.
+func (k *Keys) JWTEncryptionKey(version string) []byte {
+	return k.Derive(version, PurposeJWTEncryption, 32)
+}
+
+// JWEKeys returns the JWTStore encryption keys of every master version,
+// tagged with the version, the current one first.
+func (k *Keys) JWEKeys() []JWEKey {
+	current := k.Current()
+	keys := []JWEKey{{ID: current, Key: k.JWTEncryptionKey(current)}}
+	for _, version := range k.Versions() {
+		if version != current {
+			keys = append(keys, JWEKey{ID: version, Key: k.JWTEncryptionKey(version)})
+		}
+	}
+	return keys
+}
+
+// IDSigningKey returns the HMAC key for signing session IDs of a master
+// version.
+func (k *Keys) IDSigningKey(version string) []byte {
+	return k.Derive(version, PurposeIDSigning, 32)
+}
+
+// KeyRing returns a KeyRing with one SecureCookie per master version,
+// tagged with the version, the current one active and the others
+// decrypt-only. It fails if a version is not a valid key ID.
+func (k *Keys) KeyRing() (*KeyRing, error) {
+	ring := NewKeyRing()
+	current := k.Current()
+	for _, version := range k.Versions() {
+		codec := New(k.CookieHashKey(version), k.CookieBlockKey(version))
+		if err := ring.Add(version, codec); err != nil {
+			return nil, err
+		}
+		if version != current {
+			ring.SetState(version, KeyDecryptOnly)
+		}
+	}
+	return ring, nil
+}
@@ -0,0 +1,80 @@
+package cartsess
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKeys_Derive(t *testing.T) {
+	// Pinned so derived keys survive changes to the HKDF implementation.
+	keys, _ := NewKeys(bytes.Repeat([]byte("m"), 32))
+	tests := map[string][]byte{
+		"8e125ee08a6d301c94d3457453bef09c72aec27a1d03a9cf152be5ec80b391b1": keys.CookieHashKey("1"),
+		"9d74c6383d4f801f71fe209615470cf60e580797ae29fb46bf0fbc78ccee19e9": keys.IDSigningKey("1"),
+	}
+	for want, key := range tests {
+		if got := hex.EncodeToString(key); got != want {
+			t.Errorf("expected %s, got %s", want, got)
+		}
+	}
+	if keys.Derive("1", PurposeCookieHash, 255*32+1) != nil {
+		t.Error("expected nil beyond the HKDF output limit")
+	}
+}
+
+func TestKeys_PurposeBound(t *testing.T) {
+	keys, err := NewKeys(bytes.Repeat([]byte("m"), 32))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Equal(keys.CookieHashKey("1"), keys.JWTSigningKey("1")) ||
+		bytes.Equal(keys.JWTSigningKey("1"), keys.IDSigningKey("1")) {
+		t.Error("expected subkeys for different purposes to differ")
+	}
+	if keys.CookieHashKey("2") != nil {
+		t.Error("expected nil for an unknown version")
+	}
+}
+
+func TestKeys_CookieStoreRotation(t *testing.T) {
+	keys, _ := NewKeys(bytes.Repeat([]byte("a"), 32))
+	store, err := NewCookieStoreFromKeys(keys)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "sess")
+	session.Values["user"] = "alice"
+	session.Save(req, rec)
+
+	keys.AddMaster("2", bytes.Repeat([]byte("b"), 32))
+	store, _ = NewCookieStoreFromKeys(keys)
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(rec.Result().Cookies()[0])
+	loaded, err := store.Get(req, "sess")
+	if err != nil {
+		t.Fatalf("expected cookie from the previous master to decode: %v", err)
+	}
+	if loaded.Values["user"] != "alice" {
+		t.Errorf("expected user 'alice', got %v", loaded.Values["user"])
+	}
+	if !loaded.needsSave {
+		t.Error("expected cookie from the previous master to be re-encoded")
+	}
+}
+
+func TestKeys_KeyRingInvalidVersion(t *testing.T) {
+	keys, _ := NewKeys(bytes.Repeat([]byte("a"), 32))
+	keys.AddMaster("2:next", bytes.Repeat([]byte("b"), 32))
+	if _, err := keys.KeyRing(); err == nil {
+		t.Error("expected a version that is not a valid key ID to fail")
+	}
+	if _, err := NewCookieStoreFromKeys(keys); err == nil {
+		t.Error("expected NewCookieStoreFromKeys to report the error")
+	}
+}
@@ -51,11 +51,19 @@ func (w *writerWrapper) WriteHeader(code int) {
 
 // NewManager creates a standard net/http middleware for session management.
 func NewManager(cookieName string, store Store) func(http.Handler) http.Handler {
+	return NewManagerWithMigrations(cookieName, store, nil)
+}
+
+// NewManagerWithMigrations is like NewManager, but upgrades the values of
+// loaded sessions to the current schema version of migrations before
+// handlers see them. Migrated sessions are saved at the end of the request.
+func NewManagerWithMigrations(cookieName string, store Store, migrations *Migrations) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			s := &SessionManager{
 				cookieName: cookieName,
 				store:      store,
+				migrations: migrations,
 				request:    r,
 				written:    false,
 				response:   w,
@@ -129,6 +137,7 @@ type SessionManager struct {
 	response   http.ResponseWriter
 	session    *Session
 	written    bool
+	migrations *Migrations
 }
 
 func (s *SessionManager) Get(key string) (interface{}, error) {
@@ -171,6 +180,14 @@ func (s *SessionManager) Session() (*Session, error) {
 	var err error
 	if s.session == nil {
 		s.session, err = s.store.Get(s.request, s.cookieName)
+		if s.session != nil && s.migrations != nil {
+			if s.session.IsNew {
+				// Nothing to migrate, whatever the reason the session is new.
+				s.session.schemaVersion = s.migrations.Version()
+			} else if err == nil {
+				err = s.migrate(s.session)
+			}
+		}
 		if err != nil {
 			log.Printf(errorFormat, err)
 		}
@@ -178,6 +195,46 @@ func (s *SessionManager) Session() (*Session, error) {
 	return s.session, err
 }
 
+// migrate takes the schema version out of the values of a loaded session
+// and upgrades them to the current version, marking the session to be
+// saved if they changed.
+func (s *SessionManager) migrate(session *Session) error {
+	if _, ok := session.Values[SchemaVersionKey]; ok {
+		from, err := schemaVersion(session.Values)
+		if err != nil {
+			return err
+		}
+		// Stores such as MemoryStore hand out the stored map itself.
+		session.Values = copyValues(session.Values)
+		delete(session.Values, SchemaVersionKey)
+		session.schemaVersion = from
+	}
+	migrated, err := s.migrations.Migrate(session.Values, session.schemaVersion)
+	if err != nil {
+		return err
+	}
+	session.schemaVersion = s.migrations.Version()
+	if migrated {
+		session.needsSave = true
+	}
+	return nil
+}
+
+// save saves session with its schema version next to the values. The
+// values are copied, so stores keeping the map do not share it with
+// handlers.
+func (s *SessionManager) save(session *Session) error {
+	if session.schemaVersion == 0 {
+		return session.Save(s.request, s.response)
+	}
+	values := session.Values
+	session.Values = copyValues(values)
+	session.Values[SchemaVersionKey] = session.schemaVersion
+	err := session.Save(s.request, s.response)
+	session.Values = values
+	return err
+}
+
 // Save is a convenience method to save this session. It is the same as calling
 // store.Save(request, response, session). You should call Save before writing to
 // the response or returning from the handler.
@@ -185,7 +242,8 @@ func (s *SessionManager) Save() error {
 	if s.Written() {
 		sess, err := s.Session()
 		if err == nil {
-			err = sess.Save(s.request, s.response)
+			err = s.save(sess)
+			sess.needsSave = false
 		}
 		//end written
 		s.written = false
@@ -204,6 +262,8 @@ func (s *SessionManager) Store() Store {
 	return s.store
 }
 
+// Written reports whether the session has to be saved, either because it
+// was modified or because its store asked for it to be rewritten.
 func (s *SessionManager) Written() bool {
-	return s.written
+	return s.written || (s.session != nil && s.session.needsSave)
 }
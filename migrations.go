@@ -0,0 +1,110 @@
+package cartsess
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// SchemaVersionKey is the key under which managers with Migrations
+// persist the schema version next to the session values, so it works with
+// every store and serializer. Managers take it out of Values on load and
+// put it back only while saving: handlers never see it.
+const SchemaVersionKey = "_schema"
+
+// ErrSchemaTooNew is returned when a session was written by a newer schema
+// than the manager knows, for instance after a rollback.
+var ErrSchemaTooNew = errors.New("session schema is newer than the registered migrations")
+
+// MigrationFunc upgrades session values by one schema version, in place.
+type MigrationFunc func(values map[string]interface{}) error
+
+// Migrations is a registry of session schema migrations. Sessions saved
+// before migrations were used have no version and are version 0.
+type Migrations struct {
+	mutex   sync.RWMutex
+	steps   map[int]MigrationFunc
+	version int
+}
+
+func NewMigrations() *Migrations {
+	return &Migrations{steps: make(map[int]MigrationFunc)}
+}
+
+// Register adds the migration from version from to version from+1. The
+// current version is one above the highest registered.
+func (m *Migrations) Register(from int, fn MigrationFunc) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if from < 0 {
+		return fmt.Errorf("invalid schema version %d", from)
+	}
+	if _, ok := m.steps[from]; ok {
+		return fmt.Errorf("migration from version %d already registered", from)
+	}
+	m.steps[from] = fn
+	if from+1 > m.version {
+		m.version = from + 1
+	}
+	return nil
+}
+
+// Version returns the current schema version.
+func (m *Migrations) Version() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.version
+}
+
+// Migrate upgrades values from schema version from to the current one. It
+// reports whether any migration ran. Migrations run on a copy, so values
+// are left untouched if one fails.
+func (m *Migrations) Migrate(values map[string]interface{}, from int) (bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if from > m.version {
+		return false, ErrSchemaTooNew
+	}
+	if from == m.version {
+		return false, nil
+	}
+	migrated := copyValues(values)
+	for v := from; v < m.version; v++ {
+		fn, ok := m.steps[v]
+		if !ok {
+			return false, fmt.Errorf("no migration from schema version %d", v)
+		}
+		if err := fn(migrated); err != nil {
+			return false, fmt.Errorf("migrating from schema version %d: %w", v, err)
+		}
+	}
+	for k := range values {
+		delete(values, k)
+	}
+	for k, v := range migrated {
+		values[k] = v
+	}
+	return true, nil
+}
+
+// schemaVersion reads the version persisted in values, 0 if there is none.
+// Serializers such as JSON decode it as a float, so any whole number is
+// accepted.
+func schemaVersion(values map[string]interface{}) (int, error) {
+	switch v := values[SchemaVersionKey].(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case uint64:
+		return int(v), nil
+	case float64:
+		if v == math.Trunc(v) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid session schema version %v", values[SchemaVersionKey])
+}
@@ -0,0 +1,153 @@
+package cartsess
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	m := NewMigrations()
+	m.Register(0, func(values map[string]interface{}) error {
+		values["basket"] = values["cart"]
+		delete(values, "cart")
+		return nil
+	})
+	m.Register(1, func(values map[string]interface{}) error {
+		values["currency"] = "EUR"
+		return nil
+	})
+	if err := m.Register(1, nil); err == nil {
+		t.Error("expected an error registering a migration twice")
+	}
+	if m.Version() != 2 {
+		t.Fatalf("expected version 2, got %d", m.Version())
+	}
+
+	// A session saved before migrations were used, through a serializer
+	// that decodes numbers as floats.
+	store := NewMemoryStore()
+	store.Serializer = JSONEncoder{}
+	req, _ := http.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.New(req, "sess")
+	session.Values["cart"] = "apple"
+	if err := store.Save(req, rec, session); err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Cookie", rec.Header().Get("Set-Cookie"))
+
+	sm := &SessionManager{cookieName: "sess", store: store, migrations: m, request: req, response: httptest.NewRecorder()}
+	if v, _ := sm.Get("basket"); v != "apple" {
+		t.Errorf("expected basket to be migrated, got %v", v)
+	}
+	if v, _ := sm.Get("currency"); v != "EUR" {
+		t.Errorf("expected currency EUR, got %v", v)
+	}
+	if !sm.Written() {
+		t.Fatal("expected migrated session to be saved")
+	}
+	if err := sm.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The version is stored next to the values.
+	stored, _ := store.New(req, "sess")
+	if v := stored.Values[SchemaVersionKey]; v != 2.0 {
+		t.Errorf("expected stored version 2, got %#v", v)
+	}
+
+	// Up to date sessions are left alone, and handlers do not see the
+	// version.
+	sm = &SessionManager{cookieName: "sess", store: store, migrations: m, request: req, response: httptest.NewRecorder()}
+	if v, _ := sm.Get(SchemaVersionKey); v != nil {
+		t.Errorf("expected the version to be hidden, got %#v", v)
+	}
+	if v, _ := sm.Get("basket"); v != "apple" {
+		t.Errorf("expected basket apple, got %v", v)
+	}
+	if sm.Written() {
+		t.Error("expected current session not to be saved")
+	}
+}
+
+// renameCart is the migration from version 0 of the tests: cart becomes
+// basket.
+func renameCart(values map[string]interface{}) error {
+	values["basket"] = values["cart"]
+	delete(values, "cart")
+	return nil
+}
+
+func TestMigrations_NewSessions(t *testing.T) {
+	m := NewMigrations()
+	m.Register(0, renameCart)
+
+	for name, store := range map[string]Store{
+		"fresh":      NewMemoryStore(),
+		"bad cookie": NewCookieStore([]byte("migrations-hash-key-32-bytes-ok!")),
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if name == "bad cookie" {
+			req.AddCookie(&http.Cookie{Name: "sess", Value: "garbage"})
+		}
+		rec := httptest.NewRecorder()
+		sm := &SessionManager{cookieName: "sess", store: store, migrations: m, request: req, response: rec}
+		sm.Set("basket", "apple")
+		if err := sm.Save(); err != nil {
+			t.Fatalf("%s: failed to save: %v", name, err)
+		}
+
+		// Written with the current version, it is not migrated again.
+		req = httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(rec.Result().Cookies()[0])
+		sm = &SessionManager{cookieName: "sess", store: store, migrations: m, request: req, response: httptest.NewRecorder()}
+		if v, err := sm.Get("basket"); v != "apple" {
+			t.Errorf("%s: expected basket apple, got %v, %v", name, v, err)
+		}
+		if sm.Written() {
+			t.Errorf("%s: expected the session not to need a save", name)
+		}
+	}
+}
+
+func TestMigrations_HandlerCannotOverwriteVersion(t *testing.T) {
+	m := NewMigrations()
+	m.Register(0, renameCart)
+	store := NewMemoryStore()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	sm := &SessionManager{cookieName: "sess", store: store, migrations: m, request: req, response: rec}
+	sm.Set(SchemaVersionKey, 0)
+	sm.Set("basket", "apple")
+	sm.Save()
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(rec.Result().Cookies()[0])
+	sm = &SessionManager{cookieName: "sess", store: store, migrations: m, request: req, response: httptest.NewRecorder()}
+	if v, _ := sm.Get("basket"); v != "apple" {
+		t.Errorf("expected basket apple, got %v", v)
+	}
+}
+
+func TestMigrations_Errors(t *testing.T) {
+	m := NewMigrations()
+	m.Register(0, func(values map[string]interface{}) error {
+		values["half"] = true
+		return errors.New("boom")
+	})
+
+	values := map[string]interface{}{"cart": "apple"}
+	if _, err := m.Migrate(values, 0); err == nil {
+		t.Fatal("expected the migration error")
+	}
+	if _, ok := values["half"]; ok || len(values) != 1 {
+		t.Errorf("expected values untouched, got %v", values)
+	}
+
+	if _, err := m.Migrate(values, 5); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("expected ErrSchemaTooNew, got %v", err)
+	}
+}
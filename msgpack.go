@@ -0,0 +1,490 @@
+package cartsess
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// msgpackTimeExt is the MessagePack extension type of timestamps.
+const msgpackTimeExt = -1
+
+var errMsgpackShort = errors.New("msgpack: unexpected end of data")
+
+// MsgpackEncoder encodes values as MessagePack. Integers, floats and times
+// keep their type, and structs registered in Registry decode back to the
+// same type.
+type MsgpackEncoder struct {
+	Registry *TypeRegistry
+}
+
+// Serialize encodes a value using MessagePack.
+func (e MsgpackEncoder) Serialize(src interface{}) ([]byte, error) {
+	b, err := msgpackMarshal(e.Registry, serializable(src))
+	if err != nil {
+		return nil, cookieError{cause: err, typ: usageError}
+	}
+	return b, nil
+}
+
+// Deserialize decodes a value using MessagePack.
+func (e MsgpackEncoder) Deserialize(src []byte, dst interface{}) error {
+	v, err := msgpackUnmarshal(e.Registry, src)
+	if err == nil {
+		err = assignTo(dst, v)
+	}
+	if err != nil {
+		return cookieError{cause: err, typ: decodeError}
+	}
+	return nil
+}
+
+// assignTo stores v into the value dst points to.
+func assignTo(dst interface{}, v interface{}) error {
+	rv := reflect.ValueOf(deserializable(dst))
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("cannot decode into non-pointer %T", dst)
+	}
+	return assign(rv.Elem(), v)
+}
+
+func msgpackMarshal(registry *TypeRegistry, v interface{}) ([]byte, error) {
+	e := &msgpackEncoder{registry: registry}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+func msgpackUnmarshal(registry *TypeRegistry, b []byte) (interface{}, error) {
+	d := &msgpackDecoder{registry: registry, buf: b}
+	v, err := d.decode()
+	if err == nil && d.pos != len(b) {
+		err = errors.New("msgpack: trailing data")
+	}
+	return v, err
+}
+
+type msgpackEncoder struct {
+	registry *TypeRegistry
+	buf      []byte
+}
+
+func (e *msgpackEncoder) byte1(b byte) { e.buf = append(e.buf, b) }
+
+func (e *msgpackEncoder) uint16(b byte, n uint16) {
+	e.buf = binary.BigEndian.AppendUint16(append(e.buf, b), n)
+}
+
+func (e *msgpackEncoder) uint32(b byte, n uint32) {
+	e.buf = binary.BigEndian.AppendUint32(append(e.buf, b), n)
+}
+
+func (e *msgpackEncoder) uint64(b byte, n uint64) {
+	e.buf = binary.BigEndian.AppendUint64(append(e.buf, b), n)
+}
+
+func (e *msgpackEncoder) encodeUint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.byte1(byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		e.uint16(0xcd, uint16(n))
+	case n <= math.MaxUint32:
+		e.uint32(0xce, uint32(n))
+	default:
+		e.uint64(0xcf, n)
+	}
+}
+
+func (e *msgpackEncoder) encodeInt(n int64) {
+	switch {
+	case n >= 0:
+		e.encodeUint(uint64(n))
+	case n >= -32:
+		e.byte1(byte(n))
+	case n >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		e.uint16(0xd1, uint16(n))
+	case n >= math.MinInt32:
+		e.uint32(0xd2, uint32(n))
+	default:
+		e.uint64(0xd3, uint64(n))
+	}
+}
+
+// header writes a length header using the fix format when n fits in
+// fixMax, otherwise the 8 (if code8 is non-zero), 16 or 32-bit format.
+func (e *msgpackEncoder) header(n int, fix byte, fixMax int, code8, code16, code32 byte) {
+	switch {
+	case n <= fixMax:
+		e.byte1(fix | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		e.buf = append(e.buf, code8, byte(n))
+	case n <= math.MaxUint16:
+		e.uint16(code16, uint16(n))
+	default:
+		e.uint32(code32, uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeExt(typ int8, data []byte) {
+	switch len(data) {
+	case 1:
+		e.byte1(0xd4)
+	case 2:
+		e.byte1(0xd5)
+	case 4:
+		e.byte1(0xd6)
+	case 8:
+		e.byte1(0xd7)
+	case 16:
+		e.byte1(0xd8)
+	default:
+		e.header(len(data), 0, -1, 0xc7, 0xc8, 0xc9)
+	}
+	e.byte1(byte(typ))
+	e.buf = append(e.buf, data...)
+}
+
+func (e *msgpackEncoder) encodeTime(t time.Time) {
+	sec, nsec := t.Unix(), uint32(t.Nanosecond())
+	switch {
+	case sec>>34 == 0 && nsec == 0 && sec <= math.MaxUint32:
+		e.encodeExt(msgpackTimeExt, binary.BigEndian.AppendUint32(nil, uint32(sec)))
+	case sec>>34 == 0:
+		e.encodeExt(msgpackTimeExt, binary.BigEndian.AppendUint64(nil, uint64(nsec)<<34|uint64(sec)))
+	default:
+		data := binary.BigEndian.AppendUint32(nil, nsec)
+		e.encodeExt(msgpackTimeExt, binary.BigEndian.AppendUint64(data, uint64(sec)))
+	}
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() || v.Kind() == reflect.Pointer && v.IsNil() {
+		e.byte1(0xc0)
+		return nil
+	}
+	if id, ok := e.registry.id(v.Type()); ok {
+		payload := &msgpackEncoder{registry: e.registry}
+		if err := payload.encodeStruct(reflect.Indirect(v)); err != nil {
+			return err
+		}
+		e.encodeExt(int8(id), payload.buf)
+		return nil
+	}
+	if v.Type() == timeType {
+		e.encodeTime(v.Interface().(time.Time))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			e.byte1(0xc0)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.byte1(0xc3)
+		} else {
+			e.byte1(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32:
+		e.uint32(0xca, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.uint64(0xcb, math.Float64bits(v.Float()))
+	case reflect.String:
+		s := v.String()
+		e.header(len(s), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		e.buf = append(e.buf, s...)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			e.byte1(0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.header(len(b), 0, -1, 0xc4, 0xc5, 0xc6)
+			e.buf = append(e.buf, b...)
+			return nil
+		}
+		e.header(v.Len(), 0x90, 15, 0, 0xdc, 0xdd)
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.byte1(0xc0)
+			return nil
+		}
+		e.header(v.Len(), 0x80, 15, 0, 0xde, 0xdf)
+		for _, k := range sortedMapKeys(v) {
+			if err := e.encode(k); err != nil {
+				return err
+			}
+			if err := e.encode(v.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("msgpack: cannot encode %s", v.Type())
+	}
+	return nil
+}
+
+// encodeStruct encodes the exported fields of a struct as a map.
+func (e *msgpackEncoder) encodeStruct(v reflect.Value) error {
+	names, values := structFields(v)
+	e.header(len(names), 0x80, 15, 0, 0xde, 0xdf)
+	for i, name := range names {
+		e.header(len(name), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		e.buf = append(e.buf, name...)
+		if err := e.encode(values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type msgpackDecoder struct {
+	registry *TypeRegistry
+	buf      []byte
+	pos      int
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.buf)-d.pos < n {
+		return nil, errMsgpackShort
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// length reads a big-endian length of size bytes.
+func (d *msgpackDecoder) length(size int) (int, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return int(b[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(b)), nil
+	}
+	return int(binary.BigEndian.Uint32(b)), nil
+}
+
+func (d *msgpackDecoder) decode() (interface{}, error) {
+	c, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	code := c[0]
+	switch {
+	case code <= 0x7f:
+		return int(code), nil
+	case code >= 0xe0:
+		return int(int8(code)), nil
+	case code&0xe0 == 0xa0:
+		return d.str(int(code & 0x1f))
+	case code&0xf0 == 0x90:
+		return d.array(int(code & 0x0f))
+	case code&0xf0 == 0x80:
+		return d.mapping(int(code & 0x0f))
+	}
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		b, err := d.next(1 << (code - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		n := beUint(b)
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return intValue(int64(n)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (code - 0xd0)
+		b, err := d.next(size)
+		if err != nil {
+			return nil, err
+		}
+		shift := 64 - 8*size
+		return intValue(int64(beUint(b)<<shift) >> shift), nil
+	case 0xca:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), nil
+	case 0xcb:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.length(1 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(n)
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.length(1 << (code - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case 0xdc, 0xdd:
+		n, err := d.length(2 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(n)
+	case 0xde, 0xdf:
+		n, err := d.length(2 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapping(n)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.ext(1 << (code - 0xd4))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.length(1 << (code - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(n)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported code 0x%x", code)
+}
+
+func beUint(b []byte) uint64 {
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
+
+func (d *msgpackDecoder) str(n int) (interface{}, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) array(n int) (interface{}, error) {
+	if n > len(d.buf)-d.pos {
+		return nil, errMsgpackShort
+	}
+	items := make([]interface{}, n)
+	for i := range items {
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		items[i] = v
+	}
+	return items, nil
+}
+
+func (d *msgpackDecoder) mapping(n int) (interface{}, error) {
+	if 2*n > len(d.buf)-d.pos {
+		return nil, errMsgpackShort
+	}
+	m := make(map[string]interface{}, n)
+	var other map[interface{}]interface{}
+	for i := 0; i < n; i++ {
+		k, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		if ks, ok := k.(string); ok && other == nil {
+			m[ks] = v
+			continue
+		}
+		if other == nil {
+			other = make(map[interface{}]interface{}, n)
+			for ks, v := range m {
+				other[ks] = v
+			}
+		}
+		if k == nil {
+			return nil, errors.New("msgpack: nil map key")
+		}
+		if !reflect.TypeOf(k).Comparable() {
+			return nil, fmt.Errorf("msgpack: unhashable map key %T", k)
+		}
+		other[k] = v
+	}
+	if other != nil {
+		return other, nil
+	}
+	return m, nil
+}
+
+func (d *msgpackDecoder) ext(n int) (interface{}, error) {
+	t, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	typ := int8(t[0])
+	if typ == msgpackTimeExt {
+		switch n {
+		case 4:
+			return time.Unix(int64(binary.BigEndian.Uint32(data)), 0), nil
+		case 8:
+			v := binary.BigEndian.Uint64(data)
+			return time.Unix(int64(v&(1<<34-1)), int64(v>>34)), nil
+		case 12:
+			return time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(binary.BigEndian.Uint32(data))), nil
+		}
+		return nil, errors.New("msgpack: invalid timestamp")
+	}
+	if typ < 0 {
+		return nil, fmt.Errorf("msgpack: unsupported extension %d", typ)
+	}
+	fields, err := msgpackUnmarshal(d.registry, data)
+	if err != nil {
+		return nil, err
+	}
+	return d.registry.construct(uint8(typ), fields)
+}
@@ -0,0 +1,126 @@
+package cartsess
+
+import (
+	"encoding/gob"
+	"testing"
+	"time"
+)
+
+type testCartItem struct {
+	SKU      string
+	Quantity int
+	Added    time.Time
+}
+
+func TestBinarySerializers(t *testing.T) {
+	registry := NewTypeRegistry()
+	if err := registry.Register(1, testCartItem{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register(1, &testCartItem{}); err == nil {
+		t.Error("expected an error registering a taken id")
+	}
+
+	// Gob needs every concrete type stored in Values registered.
+	gob.Register(testCartItem{})
+	gob.Register(time.Time{})
+	gob.Register([]string{})
+	now := time.Now()
+	serializers := map[string]Serializer{
+		"msgpack": MsgpackSerializer{Registry: registry},
+		"cbor":    CBORSerializer{Registry: registry},
+	}
+	for name, sz := range serializers {
+		session := NewSession(nil, "sess")
+		session.Values["count"] = 42
+		session.Values["big"] = int64(1) << 40
+		session.Values["negative"] = -1000
+		session.Values["ratio"] = 0.25
+		session.Values["name"] = "gopher"
+		session.Values["raw"] = []byte{1, 2, 3}
+		session.Values["seen"] = now
+		session.Values["tags"] = []string{"a", "b"}
+		session.Values["item"] = testCartItem{SKU: "x-1", Quantity: 3, Added: now}
+
+		b, err := sz.Serialize(session)
+		if err != nil {
+			t.Fatalf("%s: failed to serialize: %v", name, err)
+		}
+		plain, err := GobSerializer{}.Serialize(session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) >= len(plain) {
+			t.Errorf("%s: expected fewer bytes than gob, got %d >= %d", name, len(b), len(plain))
+		}
+
+		loaded := NewSession(nil, "sess")
+		if err := sz.Deserialize(b, loaded); err != nil {
+			t.Fatalf("%s: failed to deserialize: %v", name, err)
+		}
+		if v, ok := loaded.Values["count"].(int); !ok || v != 42 {
+			t.Errorf("%s: expected int 42, got %#v", name, loaded.Values["count"])
+		}
+		if v, ok := loaded.Values["big"].(int); !ok || v != 1<<40 {
+			t.Errorf("%s: expected 1<<40, got %#v", name, loaded.Values["big"])
+		}
+		if v, ok := loaded.Values["negative"].(int); !ok || v != -1000 {
+			t.Errorf("%s: expected -1000, got %#v", name, loaded.Values["negative"])
+		}
+		if v, ok := loaded.Values["ratio"].(float64); !ok || v != 0.25 {
+			t.Errorf("%s: expected 0.25, got %#v", name, loaded.Values["ratio"])
+		}
+		if v, ok := loaded.Values["raw"].([]byte); !ok || string(v) != "\x01\x02\x03" {
+			t.Errorf("%s: expected bytes, got %#v", name, loaded.Values["raw"])
+		}
+		if v, ok := loaded.Values["seen"].(time.Time); !ok || !v.Equal(now) {
+			t.Errorf("%s: expected %v, got %#v", name, now, loaded.Values["seen"])
+		}
+		if v, ok := loaded.Values["tags"].([]interface{}); !ok || len(v) != 2 || v[1] != "b" {
+			t.Errorf("%s: expected tags, got %#v", name, loaded.Values["tags"])
+		}
+		item, ok := loaded.Values["item"].(testCartItem)
+		if !ok || item.SKU != "x-1" || item.Quantity != 3 || !item.Added.Equal(now) {
+			t.Errorf("%s: expected registered struct, got %#v", name, loaded.Values["item"])
+		}
+	}
+}
+
+func TestBinaryEncoders_Cookie(t *testing.T) {
+	for name, sz := range map[string]Serializer{
+		"msgpack": MsgpackEncoder{},
+		"cbor":    CBOREncoder{},
+	} {
+		s := New(GenerateRandomKey(32), nil)
+		s.SetSerializer(sz)
+
+		values := map[string]interface{}{"user": 7}
+		encoded, err := s.Encode("sess", values)
+		if err != nil {
+			t.Fatalf("%s: failed to encode: %v", name, err)
+		}
+		var dst map[string]interface{}
+		if err := s.Decode("sess", encoded, &dst); err != nil {
+			t.Fatalf("%s: failed to decode: %v", name, err)
+		}
+		if dst["user"] != 7 {
+			t.Errorf("%s: expected user 7, got %#v", name, dst["user"])
+		}
+
+		if err := s.Decode("sess", encoded, new(int)); err == nil {
+			t.Errorf("%s: expected an error decoding into the wrong type", name)
+		}
+	}
+}
+
+func TestMsgpackUnmarshal_InvalidKeys(t *testing.T) {
+	for name, b := range map[string][]byte{
+		"nil":   {0x81, 0xc0, 0x01},       // {nil: 1}
+		"array": {0x81, 0x91, 0x01, 0x01}, // {[1]: 1}
+		"short": {0x82, 0xa1, 'a', 0x01},  // two entries, one present
+	} {
+		if _, err := msgpackUnmarshal(nil, b); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
@@ -94,6 +95,7 @@ var (
 	errNoCodecs            = cookieError{typ: usageError, msg: "no codecs provided"}
 	errHashKeyNotSet       = cookieError{typ: usageError, msg: "hash key is not set"}
 	errBlockKeyNotSet      = cookieError{typ: usageError, msg: "block key is not set"}
+	errAEADKeyNotSet       = cookieError{typ: usageError, msg: "aead key is not set"}
 	errEncodedValueTooLong = cookieError{typ: usageError, msg: "the value is too long"}
 
 	errValueToDecodeTooLong = cookieError{typ: decodeError, msg: "the value is too long"}
@@ -101,6 +103,7 @@ var (
 	errTimestampTooNew      = cookieError{typ: decodeError, msg: "timestamp is too new"}
 	errTimestampExpired     = cookieError{typ: decodeError, msg: "expired timestamp"}
 	errDecryptionFailed     = cookieError{typ: decodeError, msg: "the value could not be decrypted"}
+	errFormatUnknown        = cookieError{typ: decodeError, msg: "unknown value format"}
 	errValueNotByte         = cookieError{typ: decodeError, msg: "value not a []byte."}
 	errValueNotBytePtr      = cookieError{typ: decodeError, msg: "value not a pointer to []byte."}
 
@@ -112,6 +115,9 @@ var (
 	ErrMacInvalid = cookieError{typ: decodeError, msg: "the value is not valid"}
 )
 
+// defaultMaxLength is the default maximum length of an encoded value.
+const defaultMaxLength = 4096
+
 // Codec defines an interface to encode and decode cookie values.
 type Codec interface {
 	Encode(name string, value interface{}) (string, error)
@@ -138,7 +144,7 @@ func New(hashKey, blockKey []byte) *SecureCookie {
 		blockKey:  blockKey,
 		hashFunc:  sha256.New,
 		maxAge:    86400 * 30,
-		maxLength: 4096,
+		maxLength: defaultMaxLength,
 		sz:        GobEncoder{},
 	}
 	if hashKey == nil {
@@ -150,6 +156,32 @@ func New(hashKey, blockKey []byte) *SecureCookie {
 	return s
 }
 
+// NewAEAD returns a new SecureCookie that encrypts and authenticates values
+// in a single AEAD pass instead of CTR encryption plus HMAC.
+//
+// key is required. AES-GCM is used by default, so the key length must be
+// 16, 24, or 32 bytes; see AEADFunc to use another algorithm such as
+// XChaCha20-Poly1305. The cookie name and timestamp are authenticated as
+// associated data.
+//
+// Values are prefixed with aeadPrefix, so codecs of both kinds can be
+// combined with DecodeMulti while rotating from one to the other.
+func NewAEAD(key []byte) *SecureCookie {
+	s := &SecureCookie{
+		aeadKey:   key,
+		hashFunc:  sha256.New,
+		maxAge:    86400 * 30,
+		maxLength: defaultMaxLength,
+		sz:        GobEncoder{},
+	}
+	if key == nil {
+		s.err = errAEADKeyNotSet
+	} else {
+		s.AEADFunc(newGCM)
+	}
+	return s
+}
+
 // SecureCookie encodes and decodes authenticated and optionally encrypted
 // cookie values.
 type SecureCookie struct {
@@ -157,6 +189,8 @@ type SecureCookie struct {
 	hashFunc  func() hash.Hash
 	blockKey  []byte
 	block     cipher.Block
+	aeadKey   []byte
+	aead      cipher.AEAD
 	maxLength int
 	maxAge    int64
 	minAge    int64
@@ -167,13 +201,6 @@ type SecureCookie struct {
 	timeFunc func() int64
 }
 
-// Serializer provides an interface for providing custom serializers for cookie
-// values.
-type Serializer interface {
-	Serialize(src interface{}) ([]byte, error)
-	Deserialize(src []byte, dst interface{}) error
-}
-
 // GobEncoder encodes cookie values using encoding/gob. This is the simplest
 // encoder and can handle complex types via gob.Register.
 type GobEncoder struct{}
@@ -234,6 +261,22 @@ func (s *SecureCookie) BlockFunc(f func([]byte) (cipher.Block, error)) *SecureCo
 	return s
 }
 
+// AEADFunc sets the function used to create the cipher.AEAD of a codec
+// created with NewAEAD.
+//
+// Default is AES-GCM. For XChaCha20-Poly1305 pass
+// golang.org/x/crypto/chacha20poly1305.NewX with a 32-byte key.
+func (s *SecureCookie) AEADFunc(f func([]byte) (cipher.AEAD, error)) *SecureCookie {
+	if s.aeadKey == nil {
+		s.err = errAEADKeyNotSet
+	} else if aead, err := f(s.aeadKey); err == nil {
+		s.aead = aead
+	} else {
+		s.err = cookieError{cause: err, typ: usageError}
+	}
+	return s
+}
+
 // Encoding sets the encoding/serialization method for cookies.
 //
 // Default is encoding/gob.  To encode special structures using encoding/gob,
@@ -260,6 +303,9 @@ func (s *SecureCookie) Encode(name string, value interface{}) (string, error) {
 	if s.err != nil {
 		return "", s.err
 	}
+	if s.aead != nil {
+		return s.encodeAEAD(name, value)
+	}
 	if s.hashKey == nil {
 		s.err = errHashKeyNotSet
 		return "", s.err
@@ -304,6 +350,9 @@ func (s *SecureCookie) Decode(name, value string, dst interface{}) error {
 	if s.err != nil {
 		return s.err
 	}
+	if s.aead != nil {
+		return s.decodeAEAD(name, value, dst)
+	}
 	if s.hashKey == nil {
 		s.err = errHashKeyNotSet
 		return s.err
@@ -357,6 +406,80 @@ func (s *SecureCookie) Decode(name, value string, dst interface{}) error {
 	return nil
 }
 
+// encodeAEAD encodes a value in the AEAD format:
+//
+//	aeadPrefix + base64(timestamp | nonce | ciphertext)
+//
+// where timestamp is 8 bytes big-endian and "name|timestamp" is the
+// associated data of the ciphertext.
+func (s *SecureCookie) encodeAEAD(name string, value interface{}) (string, error) {
+	var err error
+	var b []byte
+	// 1. Serialize.
+	if b, err = s.sz.Serialize(value); err != nil {
+		return "", cookieError{cause: err, typ: usageError}
+	}
+	// 2. Seal with name and timestamp as associated data.
+	ts := s.timestamp()
+	header := make([]byte, 8, 8+s.aead.NonceSize()+len(b)+s.aead.Overhead())
+	binary.BigEndian.PutUint64(header, uint64(ts))
+	nonce := GenerateRandomKey(s.aead.NonceSize())
+	if nonce == nil {
+		return "", errGeneratingIV
+	}
+	header = append(header, nonce...)
+	b = s.aead.Seal(header, nonce, b, aeadData(name, ts))
+	// 3. Encode to base64 and add the format prefix.
+	b = append([]byte(aeadPrefix), encode(b)...)
+	// 4. Check length.
+	if s.maxLength != 0 && len(b) > s.maxLength {
+		return "", errEncodedValueTooLong
+	}
+	// Done.
+	return string(b), nil
+}
+
+// decodeAEAD decodes a value encoded by encodeAEAD.
+func (s *SecureCookie) decodeAEAD(name, value string, dst interface{}) error {
+	// 1. Check length and format.
+	if s.maxLength != 0 && len(value) > s.maxLength {
+		return errValueToDecodeTooLong
+	}
+	rest, ok := strings.CutPrefix(value, aeadPrefix)
+	if !ok {
+		return errFormatUnknown
+	}
+	// 2. Decode from base64.
+	b, err := decode([]byte(rest))
+	if err != nil {
+		return err
+	}
+	nonceSize := s.aead.NonceSize()
+	if len(b) < 8+nonceSize+s.aead.Overhead() {
+		return ErrMacInvalid
+	}
+	// 3. Open, authenticating name and timestamp.
+	t1 := int64(binary.BigEndian.Uint64(b[:8]))
+	nonce := b[8 : 8+nonceSize]
+	if b, err = s.aead.Open(nil, nonce, b[8+nonceSize:], aeadData(name, t1)); err != nil {
+		return ErrMacInvalid
+	}
+	// 4. Verify date ranges.
+	t2 := s.timestamp()
+	if s.minAge != 0 && t1 > t2-s.minAge {
+		return errTimestampTooNew
+	}
+	if s.maxAge != 0 && t1 < t2-s.maxAge {
+		return errTimestampExpired
+	}
+	// 5. Deserialize.
+	if err = s.sz.Deserialize(b, dst); err != nil {
+		return cookieError{cause: err, typ: decodeError}
+	}
+	// Done.
+	return nil
+}
+
 // timestamp returns the current timestamp, in seconds.
 //
 // For testing purposes, the function that generates the timestamp can be
@@ -424,13 +547,32 @@ func decrypt(block cipher.Block, value []byte) ([]byte, error) {
 	return nil, errDecryptionFailed
 }
 
+// aeadPrefix marks values in the AEAD format. It contains a character
+// outside the base64 URL alphabet, so it never starts a legacy value.
+const aeadPrefix = "a1."
+
+// newGCM creates an AES-GCM AEAD.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// aeadData returns the associated data binding a value to its cookie name
+// and timestamp.
+func aeadData(name string, ts int64) []byte {
+	return []byte(name + "|" + strconv.FormatInt(ts, 10))
+}
+
 // Serialization --------------------------------------------------------------
 
 // Serialize encodes a value using gob.
 func (e GobEncoder) Serialize(src interface{}) ([]byte, error) {
 	buf := new(bytes.Buffer)
 	enc := gob.NewEncoder(buf)
-	if err := enc.Encode(src); err != nil {
+	if err := enc.Encode(serializable(src)); err != nil {
 		return nil, cookieError{cause: err, typ: usageError}
 	}
 	return buf.Bytes(), nil
@@ -439,7 +581,7 @@ func (e GobEncoder) Serialize(src interface{}) ([]byte, error) {
 // Deserialize decodes a value using gob.
 func (e GobEncoder) Deserialize(src []byte, dst interface{}) error {
 	dec := gob.NewDecoder(bytes.NewBuffer(src))
-	if err := dec.Decode(dst); err != nil {
+	if err := dec.Decode(deserializable(dst)); err != nil {
 		return cookieError{cause: err, typ: decodeError}
 	}
 	return nil
@@ -449,7 +591,7 @@ func (e GobEncoder) Deserialize(src []byte, dst interface{}) error {
 func (e JSONEncoder) Serialize(src interface{}) ([]byte, error) {
 	buf := new(bytes.Buffer)
 	enc := json.NewEncoder(buf)
-	if err := enc.Encode(src); err != nil {
+	if err := enc.Encode(serializable(src)); err != nil {
 		return nil, cookieError{cause: err, typ: usageError}
 	}
 	return buf.Bytes(), nil
@@ -458,7 +600,7 @@ func (e JSONEncoder) Serialize(src interface{}) ([]byte, error) {
 // Deserialize decodes a value using encoding/json.
 func (e JSONEncoder) Deserialize(src []byte, dst interface{}) error {
 	dec := json.NewDecoder(bytes.NewReader(src))
-	if err := dec.Decode(dst); err != nil {
+	if err := dec.Decode(deserializable(dst)); err != nil {
 		return cookieError{cause: err, typ: decodeError}
 	}
 	return nil
@@ -553,6 +695,16 @@ func CodecsFromPairs(keyPairs ...[]byte) []Codec {
 	return codecs
 }
 
+// CodecsFromAEADKeys returns a slice of SecureCookie instances created with
+// NewAEAD, one per key, for key rotation.
+func CodecsFromAEADKeys(keys ...[]byte) []Codec {
+	codecs := make([]Codec, len(keys))
+	for i, key := range keys {
+		codecs[i] = NewAEAD(key)
+	}
+	return codecs
+}
+
 // EncodeMulti encodes a cookie value using a group of codecs.
 //
 // The codecs are tried in order. Multiple codecs are accepted to allow
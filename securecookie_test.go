@@ -0,0 +1,61 @@
+package cartsess
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSecureCookie_AEADRoundTrip(t *testing.T) {
+	s := NewAEAD(GenerateRandomKey(32))
+	value := map[string]interface{}{"cart": "apple"}
+
+	encoded, err := s.Encode("sess", value)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	if !strings.HasPrefix(encoded, aeadPrefix) {
+		t.Errorf("expected %q prefix, got %q", aeadPrefix, encoded)
+	}
+
+	dst := make(map[string]interface{})
+	if err := s.Decode("sess", encoded, &dst); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if dst["cart"] != "apple" {
+		t.Errorf("expected cart 'apple', got %v", dst["cart"])
+	}
+
+	// The cookie name is authenticated.
+	if err := s.Decode("other", encoded, &dst); err == nil {
+		t.Error("expected error decoding under another name")
+	}
+	// So is every byte of the value.
+	tampered := encoded[:len(encoded)-2] + "AA"
+	if tampered != encoded {
+		if err := s.Decode("sess", tampered, &dst); err == nil {
+			t.Error("expected error decoding a tampered value")
+		}
+	}
+}
+
+func TestSecureCookie_AEADReadsLegacy(t *testing.T) {
+	legacy := New(GenerateRandomKey(32), GenerateRandomKey(16))
+	aead := NewAEAD(GenerateRandomKey(32))
+
+	encoded, err := legacy.Encode("sess", map[string]interface{}{"n": 1})
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	dst := make(map[string]interface{})
+	if err := DecodeMulti("sess", encoded, &dst, aead, legacy); err != nil {
+		t.Fatalf("expected legacy value to decode: %v", err)
+	}
+	if dst["n"] != 1 {
+		t.Errorf("expected n 1, got %v", dst["n"])
+	}
+
+	encoded, _ = aead.Encode("sess", map[string]interface{}{"n": 2})
+	if err := legacy.Decode("sess", encoded, &dst); err == nil {
+		t.Error("expected legacy codec to reject an AEAD value")
+	}
+}
@@ -0,0 +1,89 @@
+package cartsess
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Serializer turns values into bytes and back. It is the one serializer
+// abstraction of the package: SecureCookie codecs, CookieStore, RedisStore,
+// MemcachedStore and MemoryStore snapshots all accept it.
+//
+// Stores pass the *Session itself, as src to Serialize and as dst to
+// Deserialize, so serializers can use its ID; the built-in serializers
+// then encode session.Values, and decode into them. Cookie codecs pass
+// session.Values directly.
+type Serializer interface {
+	Serialize(src interface{}) ([]byte, error)
+	Deserialize(src []byte, dst interface{}) error
+}
+
+// SessionSerializer is the former store serializer interface. Wrap
+// implementations with AdaptSessionSerializer to use them as Serializer.
+type SessionSerializer interface {
+	Deserialize(d []byte, session *Session) error
+	Serialize(session *Session) ([]byte, error)
+}
+
+// Former names of the store serializers, which are now the same types as
+// the cookie serializers.
+type (
+	GobSerializer                = GobEncoder
+	JSONSerializer               = JSONEncoder
+	MsgpackSerializer            = MsgpackEncoder
+	CBORSerializer               = CBOREncoder
+	CompressingSessionSerializer = CompressingSerializer
+)
+
+var errNotSession = errors.New("value is not a session")
+
+// serializable returns what serializers encode for src: the values of a
+// *Session, or src itself.
+func serializable(src interface{}) interface{} {
+	if session, ok := src.(*Session); ok {
+		return session.Values
+	}
+	return src
+}
+
+// deserializable returns what serializers decode into for dst: the values
+// of a *Session, or dst itself.
+func deserializable(dst interface{}) interface{} {
+	if session, ok := dst.(*Session); ok {
+		return &session.Values
+	}
+	return dst
+}
+
+// AdaptSessionSerializer returns a Serializer that uses ss. It serializes
+// sessions and session value maps; cookie codecs can use it too.
+func AdaptSessionSerializer(ss SessionSerializer) Serializer {
+	return sessionSerializerAdapter{ss}
+}
+
+type sessionSerializerAdapter struct {
+	ss SessionSerializer
+}
+
+func (a sessionSerializerAdapter) Serialize(src interface{}) ([]byte, error) {
+	switch v := src.(type) {
+	case *Session:
+		return a.ss.Serialize(v)
+	case map[string]interface{}:
+		return a.ss.Serialize(&Session{Values: v})
+	}
+	return nil, fmt.Errorf("%w: %T", errNotSession, src)
+}
+
+func (a sessionSerializerAdapter) Deserialize(src []byte, dst interface{}) error {
+	switch v := dst.(type) {
+	case *Session:
+		return a.ss.Deserialize(src, v)
+	case *map[string]interface{}:
+		if *v == nil {
+			*v = make(map[string]interface{})
+		}
+		return a.ss.Deserialize(src, &Session{Values: *v})
+	}
+	return fmt.Errorf("%w: %T", errNotSession, dst)
+}
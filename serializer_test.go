@@ -0,0 +1,113 @@
+package cartsess
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// upperSerializer implements the former SessionSerializer interface.
+type upperSerializer struct{}
+
+func (upperSerializer) Serialize(session *Session) ([]byte, error) {
+	return []byte(strings.ToUpper(session.Values["name"].(string))), nil
+}
+
+func (upperSerializer) Deserialize(d []byte, session *Session) error {
+	session.Values["name"] = string(d)
+	return nil
+}
+
+func TestAdaptSessionSerializer(t *testing.T) {
+	sz := AdaptSessionSerializer(upperSerializer{})
+
+	// As a store serializer.
+	store := NewMemoryStore()
+	store.Serializer = sz
+	req, _ := http.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.New(req, "sess")
+	session.Values["name"] = "gopher"
+	if err := store.Save(req, rec, session); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	req.Header.Set("Cookie", rec.Header().Get("Set-Cookie"))
+	loaded, err := store.New(req, "sess")
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if loaded.Values["name"] != "GOPHER" {
+		t.Errorf("expected GOPHER, got %v", loaded.Values["name"])
+	}
+
+	// As a cookie serializer.
+	cs := NewCookieStore(GenerateRandomKey(32))
+	cs.SetSerializer(sz)
+	rec = httptest.NewRecorder()
+	session, _ = cs.New(req, "cookie")
+	session.Values["name"] = "gopher"
+	if err := cs.Save(req, rec, session); err != nil {
+		t.Fatalf("failed to save cookie: %v", err)
+	}
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Set("Cookie", rec.Header().Get("Set-Cookie"))
+	loaded, err = cs.New(req, "cookie")
+	if err != nil {
+		t.Fatalf("failed to load cookie: %v", err)
+	}
+	if loaded.Values["name"] != "GOPHER" {
+		t.Errorf("expected GOPHER, got %v", loaded.Values["name"])
+	}
+
+	if _, err := sz.Serialize(42); err == nil {
+		t.Error("expected an error serializing a non-session value")
+	}
+}
+
+func TestSerializer_SessionValues(t *testing.T) {
+	for name, sz := range map[string]Serializer{
+		"gob":     GobEncoder{},
+		"json":    JSONEncoder{},
+		"msgpack": MsgpackEncoder{},
+		"cbor":    CBOREncoder{},
+	} {
+		session := NewSession(nil, "sess")
+		session.Values["name"] = "gopher"
+		b, err := sz.Serialize(session)
+		if err != nil {
+			t.Fatalf("%s: failed to serialize: %v", name, err)
+		}
+		// Cookies serialize the values alone, in the same format.
+		values, _ := sz.Serialize(session.Values)
+		if string(b) != string(values) {
+			t.Errorf("%s: expected a session to serialize as its values", name)
+		}
+
+		loaded := NewSession(nil, "sess")
+		loaded.Values["kept"] = true
+		if err := sz.Deserialize(b, loaded); err != nil {
+			t.Fatalf("%s: failed to deserialize: %v", name, err)
+		}
+		if loaded.Values["name"] != "gopher" || loaded.Values["kept"] != true {
+			t.Errorf("%s: expected values to merge, got %v", name, loaded.Values)
+		}
+	}
+}
+
+func TestSetSerializer_SessionSerializer(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	store.SetSerializer(AdaptSessionSerializer(upperSerializer{}))
+	session, _ := store.Get(httptest.NewRequest("GET", "/", nil), "sess")
+	session.Values["name"] = "gopher"
+	loaded, err := store.Get(saveRedis(t, store, session), "sess")
+	if err != nil || loaded.Values["name"] != "GOPHER" {
+		t.Errorf("expected the SessionSerializer to be used, got %v, %v", loaded.Values, err)
+	}
+
+	memcached := NewMemcachedStoreWithClient(nil)
+	memcached.SetSerializer(AdaptSessionSerializer(upperSerializer{}))
+	if _, ok := memcached.Serializer.(sessionSerializerAdapter); !ok {
+		t.Errorf("expected an adapted serializer, got %T", memcached.Serializer)
+	}
+}
@@ -5,8 +5,10 @@ import (
 )
 
 type CookieStore struct {
-	Codecs  []Codec
-	Options *Options // default configuration
+	Codecs    []Codec
+	Options   *Options // default configuration
+	ChunkSize int      // largest value of a single chunk cookie, see MaxChunks
+	maxChunks int
 }
 
 var _ Store = &CookieStore{}
@@ -24,6 +26,38 @@ func NewCookieStore(keyPairs ...[]byte) *CookieStore {
 	return cs
 }
 
+// NewCookieStoreWithKeyRing creates a CookieStore whose cookies are tagged
+// with the ID of the key that encoded them. Cookies encoded by a key that
+// is no longer active are re-encoded at the end of the request.
+func NewCookieStoreWithKeyRing(ring *KeyRing) *CookieStore {
+	cs := &CookieStore{
+		Codecs: []Codec{ring},
+		Options: &Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+	}
+
+	cs.MaxAge(cs.Options.MaxAge)
+	return cs
+}
+
+// NewCookieStoreFromKeys creates a CookieStore with hash and block keys
+// derived from keys, one codec per master version. See Keys.KeyRing.
+func NewCookieStoreFromKeys(keys *Keys) (*CookieStore, error) {
+	ring, err := keys.KeyRing()
+	if err != nil {
+		return nil, err
+	}
+	return NewCookieStoreWithKeyRing(ring), nil
+}
+
+// staleCodec is implemented by codecs that can tell whether a value should
+// be re-encoded, such as KeyRing.
+type staleCodec interface {
+	IsStale(value string) bool
+}
+
 func (s *CookieStore) Get(r *http.Request, cookieName string) (session *Session, err error) {
 	session, err = s.New(r, cookieName)
 	session.cookieName = cookieName
@@ -38,10 +72,22 @@ func (s *CookieStore) New(r *http.Request, cookieName string) (*Session, error)
 	session.IsNew = true
 	var err error
 	if c, errCookie := r.Cookie(cookieName); errCookie == nil {
-		err = DecodeMulti(cookieName, c.Value, &session.Values,
+		value := c.Value
+		if isChunkHeader(value) {
+			value, err = readChunks(r, cookieName, value)
+			if err != nil {
+				return session, err
+			}
+		}
+		err = DecodeMulti(cookieName, value, &session.Values,
 			s.Codecs...)
 		if err == nil {
 			session.IsNew = false
+			for _, codec := range s.Codecs {
+				if sc, ok := codec.(staleCodec); ok && sc.IsStale(value) {
+					session.needsSave = true
+				}
+			}
 		}
 	}
 	return session, err
@@ -55,8 +101,12 @@ func (s *CookieStore) Save(r *http.Request, w http.ResponseWriter, session *Sess
 		return err
 	}
 
+	if s.maxChunks > 1 && len(encoded) > s.ChunkSize {
+		return writeChunks(r, w, session, encoded, s.ChunkSize, s.maxChunks)
+	}
 	cookie := NewCookie(session.CookieName(), encoded, session.Options)
 	http.SetCookie(w, cookie)
+	expireChunks(r, w, session, 0)
 	return nil
 }
 
@@ -69,16 +119,54 @@ func (s *CookieStore) Destroy(r *http.Request, w http.ResponseWriter, session *S
 		MaxAge:   -1,
 	}
 	http.SetCookie(w, NewCookie(session.CookieName(), "", opt))
+	expireChunks(r, w, session, 0)
 	return nil
 }
 
+// MaxChunks lets sessions too large for one cookie be split across up to
+// n cookies named name.0, name.1, ... of at most ChunkSize bytes each. The
+// maximum length of the codecs is raised to match.
+func (s *CookieStore) MaxChunks(n int) {
+	s.maxChunks = n
+	if s.ChunkSize <= 0 {
+		s.ChunkSize = defaultChunkSize
+	}
+	length := n * s.ChunkSize
+	if length < defaultMaxLength {
+		length = defaultMaxLength
+	}
+	for _, codec := range s.Codecs {
+		switch c := codec.(type) {
+		case *SecureCookie:
+			c.MaxLength(length)
+		case *KeyRing:
+			c.MaxLength(length)
+		}
+	}
+}
+
+// SetSerializer sets the serializer of the store's codecs.
+func (s *CookieStore) SetSerializer(sz Serializer) {
+	for _, codec := range s.Codecs {
+		switch c := codec.(type) {
+		case *SecureCookie:
+			c.SetSerializer(sz)
+		case *KeyRing:
+			c.SetSerializer(sz)
+		}
+	}
+}
+
 func (s *CookieStore) MaxAge(age int) {
 	s.Options.MaxAge = age
 
 	// Set the maxAge for each securecookie instance.
 	for _, codec := range s.Codecs {
-		if sc, ok := codec.(*SecureCookie); ok {
-			sc.MaxAge(age)
+		switch c := codec.(type) {
+		case *SecureCookie:
+			c.MaxAge(age)
+		case *KeyRing:
+			c.MaxAge(age)
 		}
 	}
 }
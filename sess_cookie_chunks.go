@@ -0,0 +1,115 @@
+package cartsess
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultChunkSize leaves room for the cookie name and attributes within
+// the 4096 bytes browsers accept per cookie.
+const defaultChunkSize = 3800
+
+// chunkMarker starts the header cookie of a chunked value:
+//
+//	~<count>~<checksum>
+//
+// where checksum is the base64 of the first 12 bytes of the SHA-256 of the
+// whole value. The chunks themselves are stored in name.0 ... name.<count-1>.
+// The marker is outside the base64 URL alphabet and not allowed in key IDs,
+// so it never starts a plain value.
+const chunkMarker = "~"
+
+var (
+	errTooManyChunks = cookieError{typ: usageError, msg: "the value needs too many chunks"}
+	errChunkMissing  = cookieError{typ: decodeError, msg: "chunk missing"}
+	errChunkInvalid  = cookieError{typ: decodeError, msg: "chunks do not match"}
+)
+
+func isChunkHeader(value string) bool {
+	return strings.HasPrefix(value, chunkMarker)
+}
+
+func chunkName(name string, i int) string {
+	return name + "." + strconv.Itoa(i)
+}
+
+func chunkChecksum(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// writeChunks splits value across chunk cookies and writes the header
+// under the session's cookie name. Chunks left over from a larger value
+// are expired.
+func writeChunks(r *http.Request, w http.ResponseWriter, session *Session, value string, size, max int) error {
+	count := (len(value) + size - 1) / size
+	if count > max {
+		return errTooManyChunks
+	}
+	name := session.CookieName()
+	header := chunkMarker + strconv.Itoa(count) + chunkMarker + chunkChecksum(value)
+	http.SetCookie(w, NewCookie(name, header, session.Options))
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(value) {
+			end = len(value)
+		}
+		http.SetCookie(w, NewCookie(chunkName(name, i), value[i*size:end], session.Options))
+	}
+	expireChunks(r, w, session, count)
+	return nil
+}
+
+// readChunks reassembles the value announced by header and checks it
+// against the header's checksum, so chunks from different saves are never
+// mixed.
+func readChunks(r *http.Request, name, header string) (string, error) {
+	countStr, sum, ok := strings.Cut(header[len(chunkMarker):], chunkMarker)
+	if !ok {
+		return "", errChunkInvalid
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count <= 0 {
+		return "", errChunkInvalid
+	}
+	var b strings.Builder
+	for i := 0; i < count; i++ {
+		c, err := r.Cookie(chunkName(name, i))
+		if err != nil {
+			return "", errChunkMissing
+		}
+		b.WriteString(c.Value)
+	}
+	value := b.String()
+	if subtle.ConstantTimeCompare([]byte(chunkChecksum(value)), []byte(sum)) != 1 {
+		return "", errChunkInvalid
+	}
+	return value, nil
+}
+
+// expireChunks expires the chunk cookies sent with the request from index
+// from onwards.
+func expireChunks(r *http.Request, w http.ResponseWriter, session *Session, from int) {
+	name := session.CookieName()
+	opt := &Options{
+		Path:     session.Options.Path,
+		Domain:   session.Options.Domain,
+		Secure:   session.Options.Secure,
+		HttpOnly: session.Options.HttpOnly,
+		SameSite: session.Options.SameSite,
+		MaxAge:   -1,
+	}
+	for _, c := range r.Cookies() {
+		idx, ok := strings.CutPrefix(c.Name, name+".")
+		if !ok {
+			continue
+		}
+		if i, err := strconv.Atoi(idx); err == nil && i >= from {
+			http.SetCookie(w, NewCookie(c.Name, "", opt))
+		}
+	}
+}
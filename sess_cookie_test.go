@@ -0,0 +1,82 @@
+package cartsess
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCookieStore_Chunks(t *testing.T) {
+	store := NewCookieStore(GenerateRandomKey(32))
+	store.MaxChunks(4)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "sess")
+	session.Values["cart"] = strings.Repeat("x", 6000)
+	if err := session.Save(req, rec); err != nil {
+		t.Fatalf("failed to save large session: %v", err)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) < 3 {
+		t.Fatalf("expected header and chunk cookies, got %d", len(cookies))
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	loaded, err := store.Get(req, "sess")
+	if err != nil {
+		t.Fatalf("failed to load chunked session: %v", err)
+	}
+	if loaded.Values["cart"] != session.Values["cart"] {
+		t.Error("expected reassembled value to match")
+	}
+
+	// Shrinking the session expires the chunks, with matching attributes.
+	loaded.Values["cart"] = "small"
+	loaded.Options.SameSite = http.SameSiteStrictMode
+	rec = httptest.NewRecorder()
+	loaded.Save(req, rec)
+	expired := 0
+	for _, c := range rec.Result().Cookies() {
+		if strings.HasPrefix(c.Name, "sess.") && c.MaxAge == -1 {
+			expired++
+			if c.SameSite != http.SameSiteStrictMode {
+				t.Errorf("expected chunk %s to keep SameSite, got %v", c.Name, c.SameSite)
+			}
+		}
+	}
+	if expired != len(cookies)-1 {
+		t.Errorf("expected %d stale chunks to be expired, got %d", len(cookies)-1, expired)
+	}
+}
+
+func TestCookieStore_ChunksMismatch(t *testing.T) {
+	store := NewCookieStore(GenerateRandomKey(32))
+	store.MaxChunks(4)
+
+	save := func(v string) []*http.Cookie {
+		req := httptest.NewRequest("GET", "/", nil)
+		rec := httptest.NewRecorder()
+		session, _ := store.Get(req, "sess")
+		session.Values["cart"] = v
+		session.Save(req, rec)
+		return rec.Result().Cookies()
+	}
+	first := save(strings.Repeat("a", 6000))
+	second := save(strings.Repeat("b", 6000))
+
+	// Header and first chunk from one save, the rest from another.
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(first[0])
+	req.AddCookie(first[1])
+	for _, c := range second[2:] {
+		req.AddCookie(c)
+	}
+	if _, err := store.Get(req, "sess"); err != errChunkInvalid {
+		t.Errorf("expected errChunkInvalid, got %v", err)
+	}
+}
@@ -1,9 +1,9 @@
 package cartsess
 
 import (
+	"crypto"
 	"errors"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -15,6 +15,55 @@ type JWTStore struct {
 	SigningKey    []byte            // Secret key for signing tokens
 	SigningMethod jwt.SigningMethod // Signing algorithm (default: HS256)
 	Options       *Options          // Cookie options
+	// PrivateKey signs tokens instead of SigningKey for RS*, PS*, ES* and
+	// EdDSA methods.
+	PrivateKey crypto.Signer
+	// KeyID is written as the kid header of signed tokens.
+	KeyID string
+	// VerificationKeys are accepted in addition to the signing key, for
+	// instance the keys of other issuers or keys being rotated out.
+	VerificationKeys []JWTKey
+
+	Issuer       string        // iss of issued tokens, required on load when set
+	Audience     []string      // aud of issued tokens; loaded tokens must name one of them
+	SubjectKey   string        // session value written as sub, such as the user ID
+	NotBefore    bool          // write nbf, equal to iat
+	TokenIDs     bool          // write a random jti
+	Leeway       time.Duration // clock skew tolerated when checking exp, nbf and iat
+	ClaimMappers []ClaimMapper // add top-level claims from session values
+
+	// ReissueAfter is the fraction of their lifetime, such as 0.5, after
+	// which loaded tokens are re-issued with a fresh exp, even if the
+	// session did not change. The session manager saves such sessions at
+	// the end of the request. Zero never re-issues.
+	ReissueAfter float64
+
+	// Families enables refresh tokens: sessions passed to Login start a
+	// family whose refresh token RefreshHandler exchanges for new tokens.
+	Families    RefreshStore
+	RefreshTTL  time.Duration // lifetime of refresh tokens (default 30 days)
+	RefreshPath string        // path of the refresh token cookie, such as the refresh endpoint
+	// RefreshTransport carries refresh tokens, as cookies named after the
+	// session with a "_refresh" suffix. The default follows Transport:
+	// cookies stay cookies, headers become X-Refresh-Token (also read from
+	// the refresh_token form field) and query parameters are dropped.
+	RefreshTransport TokenTransport
+
+	// Denylist is checked for revoked tokens on load. Tokens get a jti when
+	// it is set, and Destroy revokes the token of the session.
+	Denylist Denylist
+
+	// EncryptionKeys make tokens compact JWEs (dir, A256GCM), so clients
+	// cannot read session values. The first key encrypts; the others
+	// still decrypt the tokens carrying their kid.
+	EncryptionKeys []JWEKey
+	Encryption     JWEMode // what is encrypted (default JWENested)
+
+	// Transport carries tokens between client and server. The default
+	// reads "Authorization: Bearer <token>", then the cookie, rejecting
+	// requests where they differ, and writes both the cookie and
+	// X-JWT-Token.
+	Transport TokenTransport
 }
 
 var _ Store = &JWTStore{}
@@ -40,8 +89,27 @@ func NewJWTStoreWithKeyValidation(signingKey []byte) (*JWTStore, error) {
 	return NewJWTStore(signingKey), nil
 }
 
+// NewJWTStoreFromKeys creates a new JWTStore signing with the key derived
+// from the current master of keys, with the master version as kid. Tokens
+// signed with the keys of the other versions are still accepted.
+func NewJWTStoreFromKeys(keys *Keys) *JWTStore {
+	current := keys.Current()
+	s := NewJWTStore(keys.JWTSigningKey(current))
+	s.KeyID = current
+	for _, version := range keys.Versions() {
+		if version != current {
+			s.VerificationKeys = append(s.VerificationKeys, JWTKey{
+				ID:     version,
+				Method: jwt.SigningMethodHS256,
+				Key:    keys.JWTSigningKey(version),
+			})
+		}
+	}
+	return s
+}
+
 // Get retrieves a session from the request.
-// It checks Authorization header first, then falls back to cookie.
+// The token is read with Transport.
 func (s *JWTStore) Get(r *http.Request, name string) (*Session, error) {
 	session, err := s.New(r, name)
 	session.cookieName = name
@@ -57,67 +125,83 @@ func (s *JWTStore) New(r *http.Request, name string) (*Session, error) {
 	session.IsNew = true
 
 	// Try to get token from request
-	tokenString := s.getTokenFromRequest(r, name)
+	tokenString, err := s.transport().Token(r, name)
+	if err != nil {
+		return session, err
+	}
 	if tokenString == "" {
 		return session, nil
 	}
 
 	// Parse and validate token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if token.Method.Alg() != s.SigningMethod.Alg() {
-			return nil, errors.New("invalid signing method")
-		}
-		return s.SigningKey, nil
-	})
-
+	claims, err := s.decode(tokenString)
 	if err != nil {
 		return session, err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		// Extract session data from claims
-		if data, exists := claims["data"].(map[string]interface{}); exists {
-			session.Values = data
-			session.IsNew = false
+	session.tokenID, _ = claims["jti"].(string)
+	if exp, _ := claims.GetExpirationTime(); exp != nil {
+		session.tokenExpiry = exp.Time
+	}
+	if s.Denylist != nil {
+		sub, _ := claims.GetSubject()
+		iat, _ := claims.GetIssuedAt()
+		var issuedAt time.Time
+		if iat != nil {
+			issuedAt = iat.Time
+		}
+		if err := s.checkDenylist(session, sub, issuedAt); err != nil {
+			session.tokenID, session.tokenExpiry = "", time.Time{}
+			return session, err
 		}
 	}
 
+	// Extract session data from claims
+	if data, exists := claims["data"].(map[string]interface{}); exists {
+		session.Values = data
+		session.IsNew = false
+	}
+	session.tokenFamily, _ = claims["fid"].(string)
+	if s.ReissueAfter > 0 && !session.IsNew {
+		session.needsSave = s.reissueDue(claims, time.Now())
+	}
+
 	return session, nil
 }
 
-// Save encodes the session as a JWT token and sets it as a cookie.
+// Save encodes the session as a JWT token and hands it to the client with
+// Transport.
 func (s *JWTStore) Save(r *http.Request, w http.ResponseWriter, session *Session) error {
-	// Create claims
-	now := time.Now()
-	claims := jwt.MapClaims{
-		"data": session.Values,
-		"iat":  now.Unix(),
+	if s.Families != nil {
+		if err := s.saveFamily(w, session); err != nil {
+			return err
+		}
 	}
+	_, err := s.issue(w, session)
+	return err
+}
 
-	// Add expiration if MaxAge is set
-	if session.Options.MaxAge > 0 {
-		claims["exp"] = now.Add(time.Duration(session.Options.MaxAge) * time.Second).Unix()
-	}
+// issue signs an access token for session and hands it to the client.
+func (s *JWTStore) issue(w http.ResponseWriter, session *Session) (string, error) {
+	// Create claims
+	claims := s.claims(session, time.Now())
 
-	// Create and sign token
-	token := jwt.NewWithClaims(s.SigningMethod, claims)
-	tokenString, err := token.SignedString(s.SigningKey)
+	// Create, sign and encrypt token
+	tokenString, err := s.encode(claims)
 	if err != nil {
-		return err
+		return "", err
+	}
+	session.tokenID, _ = claims["jti"].(string)
+	if exp, _ := claims.GetExpirationTime(); exp != nil {
+		session.tokenExpiry = exp.Time
 	}
 
-	// Set cookie
-	cookie := NewCookie(session.CookieName(), tokenString, session.Options)
-	http.SetCookie(w, cookie)
-
-	// Also set token in response header for API clients
-	w.Header().Set("X-JWT-Token", tokenString)
-
-	return nil
+	s.transport().SetToken(w, session.CookieName(), tokenString, session.Options)
+	return tokenString, nil
 }
 
-// Destroy removes the session by setting an expired cookie.
+// Destroy removes the session by setting an expired cookie, or whatever
+// Transport does to clear tokens.
 func (s *JWTStore) Destroy(r *http.Request, w http.ResponseWriter, session *Session) error {
 	opt := &Options{
 		Path:     session.Options.Path,
@@ -126,8 +210,15 @@ func (s *JWTStore) Destroy(r *http.Request, w http.ResponseWriter, session *Sess
 		HttpOnly: session.Options.HttpOnly,
 		MaxAge:   -1,
 	}
-	http.SetCookie(w, NewCookie(session.CookieName(), "", opt))
-	return nil
+	s.transport().SetToken(w, session.CookieName(), "", opt)
+	var errs []error
+	if s.Denylist != nil {
+		errs = append(errs, s.revokeToken(session))
+	}
+	if s.Families != nil {
+		errs = append(errs, s.revokeFamily(w, session))
+	}
+	return errors.Join(errs...)
 }
 
 // MaxAge sets the maximum age for the store's options.
@@ -135,24 +226,10 @@ func (s *JWTStore) MaxAge(age int) {
 	s.Options.MaxAge = age
 }
 
-// getTokenFromRequest extracts JWT token from request.
-// Priority: Authorization header > Cookie
-func (s *JWTStore) getTokenFromRequest(r *http.Request, cookieName string) string {
-	// 1. Check Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader != "" {
-		// Support "Bearer <token>" format
-		if token, ok := strings.CutPrefix(authHeader, "Bearer "); ok {
-			return token
-		}
-		// Also support raw token
-		return authHeader
+// transport returns the transport of tokens.
+func (s *JWTStore) transport() TokenTransport {
+	if s.Transport != nil {
+		return s.Transport
 	}
-
-	// 2. Fall back to cookie
-	if cookie, err := r.Cookie(cookieName); err == nil {
-		return cookie.Value
-	}
-
-	return ""
+	return defaultTransport
 }
@@ -0,0 +1,120 @@
+package cartsess
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ClaimMapper adds top-level claims derived from session values to the
+// claims of a token being issued. Claims the store manages, the registered
+// claims, data and fid, are removed from what the mappers set, so mappers
+// cannot override or inject them.
+type ClaimMapper func(values map[string]interface{}, claims jwt.MapClaims)
+
+// LiftClaims returns a ClaimMapper copying the session values of keys, when
+// set, to top-level claims of the same name.
+func LiftClaims(keys ...string) ClaimMapper {
+	return func(values map[string]interface{}, claims jwt.MapClaims) {
+		for _, key := range keys {
+			if v, ok := values[key]; ok {
+				claims[key] = v
+			}
+		}
+	}
+}
+
+// reservedClaims are the claims set by the store only.
+var reservedClaims = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti", "data", "fid"}
+
+// claims builds the claims of a token for session issued at now.
+func (s *JWTStore) claims(session *Session, now time.Time) jwt.MapClaims {
+	claims := jwt.MapClaims{}
+	for _, mapper := range s.ClaimMappers {
+		mapper(session.Values, claims)
+	}
+	for _, name := range reservedClaims {
+		delete(claims, name)
+	}
+	claims["data"] = session.Values
+	claims["iat"] = now.Unix()
+
+	// Add expiration if MaxAge is set
+	if session.Options.MaxAge > 0 {
+		claims["exp"] = now.Add(time.Duration(session.Options.MaxAge) * time.Second).Unix()
+	}
+	if s.Issuer != "" {
+		claims["iss"] = s.Issuer
+	}
+	switch len(s.Audience) {
+	case 0:
+	case 1:
+		claims["aud"] = s.Audience[0]
+	default:
+		claims["aud"] = s.Audience
+	}
+	if sub := s.subject(session.Values); sub != "" {
+		claims["sub"] = sub
+	}
+	if s.NotBefore {
+		claims["nbf"] = now.Unix()
+	}
+	if s.TokenIDs || s.Denylist != nil {
+		claims["jti"] = newTokenID()
+	}
+	if session.tokenFamily != "" {
+		claims["fid"] = session.tokenFamily
+	}
+	return claims
+}
+
+// subject returns the sub claim of a session with values: the value under
+// SubjectKey, or "" if there is none.
+func (s *JWTStore) subject(values map[string]interface{}) string {
+	if s.SubjectKey == "" {
+		return ""
+	}
+	if sub, ok := values[s.SubjectKey]; ok && sub != nil {
+		return fmt.Sprint(sub)
+	}
+	return ""
+}
+
+// parserOptions returns the validation applied to tokens on load.
+func (s *JWTStore) parserOptions() []jwt.ParserOption {
+	opts := []jwt.ParserOption{jwt.WithLeeway(s.Leeway), jwt.WithIssuedAt()}
+	if s.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(s.Issuer))
+	}
+	if len(s.Audience) > 0 {
+		opts = append(opts, jwt.WithAudience(s.Audience...))
+	}
+	if s.Options.MaxAge > 0 {
+		opts = append(opts, jwt.WithExpirationRequired())
+	}
+	return opts
+}
+
+// reissueDue reports whether a token with claims has passed ReissueAfter
+// of its lifetime at now. Tokens without iat or exp are never due.
+func (s *JWTStore) reissueDue(claims jwt.MapClaims, now time.Time) bool {
+	iat, _ := claims.GetIssuedAt()
+	exp, _ := claims.GetExpirationTime()
+	if iat == nil || exp == nil {
+		return false
+	}
+	lifetime := exp.Sub(iat.Time)
+	return now.Sub(iat.Time) >= time.Duration(float64(lifetime)*s.ReissueAfter)
+}
+
+// newTokenID returns a random jti.
+func newTokenID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
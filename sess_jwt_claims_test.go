@@ -0,0 +1,143 @@
+package cartsess
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestJWTStore_RegisteredClaims(t *testing.T) {
+	store := NewJWTStore([]byte("test-secret-key"))
+	store.Issuer = "https://shop.example"
+	store.Audience = []string{"shop", "billing"}
+	store.SubjectKey = "user_id"
+	store.NotBefore = true
+	store.TokenIDs = true
+	store.ClaimMappers = []ClaimMapper{LiftClaims("role", "exp")}
+
+	session := NewSession(store, "jwt-session")
+	session.Options = store.Options
+	session.Values["user_id"] = 42
+	session.Values["role"] = "admin"
+	session.Values["exp"] = "not an expiry"
+	claims := store.claims(session, time.Now())
+	if claims["iss"] != "https://shop.example" || claims["sub"] != "42" || claims["role"] != "admin" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+	if claims["nbf"] != claims["iat"] || claims["jti"] == "" || claims["jti"] == nil {
+		t.Errorf("expected nbf and jti, got %v", claims)
+	}
+	if _, ok := claims["exp"].(int64); !ok {
+		t.Errorf("expected mappers not to override exp, got %v", claims["exp"])
+	}
+
+	token := issueJWT(t, store)
+	if _, err := loadJWT(store, token); err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+
+	// A service with another audience or issuer rejects the token.
+	other := NewJWTStore([]byte("test-secret-key"))
+	other.Audience = []string{"admin"}
+	if _, err := loadJWT(other, token); err == nil {
+		t.Error("expected token for another audience to be rejected")
+	}
+	other = NewJWTStore([]byte("test-secret-key"))
+	other.Issuer = "https://evil.example"
+	if _, err := loadJWT(other, token); err == nil {
+		t.Error("expected token of another issuer to be rejected")
+	}
+}
+
+func TestJWTStore_ClaimMappersCannotInjectReserved(t *testing.T) {
+	store := NewJWTStore([]byte("test-secret-key"))
+	store.MaxAge(0)
+	store.ClaimMappers = []ClaimMapper{func(values map[string]interface{}, claims jwt.MapClaims) {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		claims["nbf"] = time.Now().Unix()
+		claims["fid"] = "family"
+		claims["role"] = "admin"
+	}}
+
+	session := NewSession(store, "jwt-session")
+	session.Options = store.Options
+	claims := store.claims(session, time.Now())
+	for _, name := range []string{"exp", "nbf", "fid"} {
+		if _, ok := claims[name]; ok {
+			t.Errorf("expected %s not to be set by a mapper, got %v", name, claims)
+		}
+	}
+	if claims["role"] != "admin" {
+		t.Errorf("expected other claims to be kept, got %v", claims)
+	}
+}
+
+func TestJWTStore_Leeway(t *testing.T) {
+	store := NewJWTStore([]byte("test-secret-key"))
+
+	// A token issued by a server whose clock is ahead.
+	now := time.Now().Add(3 * time.Second)
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"data": map[string]interface{}{"user": "gopher"},
+		"iat":  now.Unix(),
+		"nbf":  now.Unix(),
+		"exp":  now.Add(time.Hour).Unix(),
+	}).SignedString([]byte("test-secret-key"))
+
+	if _, err := loadJWT(store, token); err == nil {
+		t.Error("expected token from the future to be rejected")
+	}
+	store.Leeway = 10 * time.Second
+	if _, err := loadJWT(store, token); err != nil {
+		t.Errorf("expected leeway to accept the token: %v", err)
+	}
+}
+
+func TestJWTStore_Reissue(t *testing.T) {
+	store := NewJWTStore([]byte("test-secret-key"))
+	store.MaxAge(600)
+	store.ReissueAfter = 0.5
+
+	// tokenAt returns a token issued age ago.
+	tokenAt := func(age time.Duration) string {
+		session := NewSession(store, "jwt-session")
+		session.Options = store.Options
+		session.Values["user"] = "gopher"
+		token, err := store.encode(store.claims(session, time.Now().Add(-age)))
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		return token
+	}
+
+	session, err := loadJWT(store, tokenAt(time.Minute))
+	if err != nil || session.needsSave {
+		t.Errorf("expected a fresh token to be kept, got %v", err)
+	}
+
+	// Past half its lifetime, a read-only request gets a new token.
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenAt(6*time.Minute))
+	rec := httptest.NewRecorder()
+	sm := &SessionManager{cookieName: "jwt-session", store: store, request: req, response: rec}
+	if v, _ := sm.Get("user"); v != "gopher" {
+		t.Fatalf("failed to load the session, got %v", v)
+	}
+	if !sm.Written() {
+		t.Fatal("expected the session to be re-issued")
+	}
+	if err := sm.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	session, err = loadJWT(store, rec.Header().Get("X-JWT-Token"))
+	if err != nil || session.needsSave || session.Values["user"] != "gopher" {
+		t.Errorf("expected a fresh token with the same values, got %v, %v", session.Values, err)
+	}
+
+	// Expired tokens are not re-issued.
+	if _, err := loadJWT(store, tokenAt(11*time.Minute)); err == nil {
+		t.Error("expected an expired token to be rejected")
+	}
+}
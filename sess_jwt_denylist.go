@@ -0,0 +1,151 @@
+package cartsess
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTokenRevoked is returned when a session token was revoked.
+var ErrTokenRevoked = errors.New("token revoked")
+
+// Denylist records revoked JWTs until they would have expired anyway.
+type Denylist interface {
+	// Revoke denies the token with ID jti until expires.
+	Revoke(ctx context.Context, jti string, expires time.Time) error
+	// RevokeSubject denies the tokens of subject issued before before,
+	// until expires.
+	RevokeSubject(ctx context.Context, subject string, before, expires time.Time) error
+	// IsRevoked reports whether a token with ID jti, of subject, issued at
+	// issuedAt is denied. jti and subject may be empty.
+	IsRevoked(ctx context.Context, jti, subject string, issuedAt time.Time) (bool, error)
+}
+
+// RevokeSubject revokes every token of subject, as written under
+// SubjectKey, issued before t, such as for "log out everywhere". Tokens
+// have a resolution of one second: tokens issued in the second of t stay
+// valid. Refresh families of logins before t can no longer be refreshed
+// either, so the revocation is kept as long as they may live.
+func (s *JWTStore) RevokeSubject(ctx context.Context, subject string, t time.Time) error {
+	if s.Denylist == nil {
+		return errors.New("jwt store has no denylist")
+	}
+	age := s.maxTokenAge()
+	if s.Families != nil && s.refreshTTL() > age {
+		age = s.refreshTTL()
+	}
+	return s.Denylist.RevokeSubject(ctx, subject, t, t.Add(age))
+}
+
+// maxTokenAge returns how long after being issued a token may be accepted.
+func (s *JWTStore) maxTokenAge() time.Duration {
+	age := time.Duration(s.Options.MaxAge)*time.Second + s.Leeway
+	if s.Options.MaxAge <= 0 {
+		// Tokens without exp never expire; keep revocations for a year.
+		age = 365 * 24 * time.Hour
+	}
+	return age
+}
+
+// checkDenylist returns ErrTokenRevoked if the token a session was loaded
+// from is revoked.
+func (s *JWTStore) checkDenylist(session *Session, subject string, issuedAt time.Time) error {
+	ctx, cancel := Context()
+	defer cancel()
+	revoked, err := s.Denylist.IsRevoked(ctx, session.tokenID, subject, issuedAt)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// revokeToken denies the token a destroyed session was loaded from.
+func (s *JWTStore) revokeToken(session *Session) error {
+	if session.tokenID == "" {
+		return nil
+	}
+	expires := session.tokenExpiry
+	if expires.IsZero() {
+		expires = time.Now().Add(s.maxTokenAge())
+	}
+	ctx, cancel := Context()
+	defer cancel()
+	return s.Denylist.Revoke(ctx, session.tokenID, expires.Add(s.Leeway))
+}
+
+// MemoryDenylist keeps revocations in memory, for single instance
+// deployments and tests. Entries are dropped once they expire.
+type MemoryDenylist struct {
+	mutex     sync.Mutex
+	tokens    map[string]time.Time
+	subjects  map[string]memorySubjectRevocation
+	lastPurge time.Time
+}
+
+type memorySubjectRevocation struct {
+	before, expires time.Time
+}
+
+var _ Denylist = &MemoryDenylist{}
+
+func NewMemoryDenylist() *MemoryDenylist {
+	return &MemoryDenylist{
+		tokens:   make(map[string]time.Time),
+		subjects: make(map[string]memorySubjectRevocation),
+	}
+}
+
+// purge drops expired entries, at most once a minute. The mutex must be
+// held.
+func (m *MemoryDenylist) purge(now time.Time) {
+	if now.Sub(m.lastPurge) < time.Minute {
+		return
+	}
+	m.lastPurge = now
+	for jti, expires := range m.tokens {
+		if now.After(expires) {
+			delete(m.tokens, jti)
+		}
+	}
+	for subject, r := range m.subjects {
+		if now.After(r.expires) {
+			delete(m.subjects, subject)
+		}
+	}
+}
+
+func (m *MemoryDenylist) Revoke(ctx context.Context, jti string, expires time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.purge(time.Now())
+	m.tokens[jti] = expires
+	return nil
+}
+
+func (m *MemoryDenylist) RevokeSubject(ctx context.Context, subject string, before, expires time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.purge(time.Now())
+	if r, ok := m.subjects[subject]; ok && r.before.After(before) {
+		return nil
+	}
+	m.subjects[subject] = memorySubjectRevocation{before: before, expires: expires}
+	return nil
+}
+
+func (m *MemoryDenylist) IsRevoked(ctx context.Context, jti, subject string, issuedAt time.Time) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	if expires, ok := m.tokens[jti]; ok && jti != "" && now.Before(expires) {
+		return true, nil
+	}
+	if r, ok := m.subjects[subject]; ok && subject != "" && now.Before(r.expires) {
+		return issuedAt.Unix() < r.before.Unix(), nil
+	}
+	return false, nil
+}
@@ -0,0 +1,87 @@
+package cartsess
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Key prefixes of denylist entries, inserted between Prefix and the token
+// ID or subject.
+const (
+	denyTokenPrefix   = "deny:jti:"
+	denySubjectPrefix = "deny:sub:"
+)
+
+// revokeSubjectScript moves the revocation time of a subject forward only.
+var revokeSubjectScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+if tonumber(ARGV[1]) > current then
+	redis.call('SET', KEYS[1], ARGV[1])
+	redis.call('PEXPIREAT', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// RedisDenylist keeps revocations in redis keys that expire with the
+// revoked tokens.
+type RedisDenylist struct {
+	Client redis.UniversalClient
+	Prefix string
+}
+
+var _ Denylist = &RedisDenylist{}
+
+func NewRedisDenylist(client redis.UniversalClient) *RedisDenylist {
+	return &RedisDenylist{Client: client}
+}
+
+func (d *RedisDenylist) Revoke(ctx context.Context, jti string, expires time.Time) error {
+	key := d.Prefix + denyTokenPrefix + jti
+	_, err := d.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, "1", 0)
+		pipe.PExpireAt(ctx, key, expires)
+		return nil
+	})
+	return err
+}
+
+func (d *RedisDenylist) RevokeSubject(ctx context.Context, subject string, before, expires time.Time) error {
+	key := d.Prefix + denySubjectPrefix + subject
+	return revokeSubjectScript.Run(ctx, d.Client, []string{key}, before.Unix(), expires.UnixMilli()).Err()
+}
+
+// IsRevoked looks the token and its subject up in one round trip. The keys
+// are read separately so they may live on different cluster nodes.
+func (d *RedisDenylist) IsRevoked(ctx context.Context, jti, subject string, issuedAt time.Time) (bool, error) {
+	if jti == "" && subject == "" {
+		return false, nil
+	}
+	var token, before *redis.StringCmd
+	_, err := d.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if jti != "" {
+			token = pipe.Get(ctx, d.Prefix+denyTokenPrefix+jti)
+		}
+		if subject != "" {
+			before = pipe.Get(ctx, d.Prefix+denySubjectPrefix+subject)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return false, err
+	}
+	if token != nil && token.Err() == nil {
+		return true, nil
+	}
+	if before != nil && before.Err() == nil {
+		t, err := strconv.ParseInt(before.Val(), 10, 64)
+		if err != nil {
+			return false, err
+		}
+		return issuedAt.Unix() < t, nil
+	}
+	return false, nil
+}
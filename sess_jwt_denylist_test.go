@@ -0,0 +1,71 @@
+package cartsess
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestJWTStore_Denylist(t *testing.T) {
+	store := NewJWTStore([]byte("test-secret-key"))
+	store.Denylist = NewMemoryDenylist()
+
+	token := issueJWT(t, store)
+	session, err := loadJWT(store, token)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if session.tokenID == "" {
+		t.Fatal("expected tokens to get a jti")
+	}
+	if err := session.Destroy(httptest.NewRequest("GET", "/", nil), httptest.NewRecorder()); err != nil {
+		t.Fatalf("failed to destroy: %v", err)
+	}
+
+	// The token returned in X-JWT-Token no longer works after logout.
+	session, err = loadJWT(store, token)
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("expected ErrTokenRevoked, got %v", err)
+	}
+	if !session.IsNew || len(session.Values) != 0 {
+		t.Errorf("expected an empty session, got %v", session.Values)
+	}
+
+	// Other tokens are unaffected.
+	if _, err := loadJWT(store, issueJWT(t, store)); err != nil {
+		t.Errorf("expected other tokens to load: %v", err)
+	}
+}
+
+func TestJWTStore_RevokeSubject(t *testing.T) {
+	store := NewJWTStore([]byte("test-secret-key"))
+	store.Denylist = NewMemoryDenylist()
+	store.SubjectKey = "user"
+
+	token := issueJWT(t, store)
+	if err := store.RevokeSubject(context.Background(), "gopher", time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("failed to revoke subject: %v", err)
+	}
+	if _, err := loadJWT(store, token); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("expected tokens of the subject to be revoked, got %v", err)
+	}
+
+	// An earlier cutoff does not undo a later one.
+	store.RevokeSubject(context.Background(), "gopher", time.Now().Add(-time.Hour))
+	if _, err := loadJWT(store, token); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("expected revocation to stay, got %v", err)
+	}
+
+	other := NewJWTStore([]byte("test-secret-key"))
+	other.SubjectKey = "user"
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := other.Get(req, "jwt-session")
+	session.Values["user"] = "other"
+	session.Save(req, rec)
+	if _, err := loadJWT(store, rec.Header().Get("X-JWT-Token")); err != nil {
+		t.Errorf("expected tokens of other subjects to load: %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package cartsess
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	errJWEMalformed  = errors.New("malformed jwe token")
+	errJWEAlgorithm  = errors.New("unsupported jwe algorithm")
+	errJWEUnknownKey = errors.New("unknown jwe key id")
+	errJWEKeySize    = errors.New("jwe key must be 32 bytes")
+	errJWEDecrypt    = errors.New("jwe decryption failed")
+)
+
+// JWEMode selects what an encrypting JWTStore puts inside the JWE.
+type JWEMode int
+
+const (
+	// JWENested encrypts the signed JWT, so the token is both signed and
+	// encrypted. It needs a signing key as well as an encryption key.
+	JWENested JWEMode = iota
+	// JWEDirect encrypts the claims themselves. The token is only
+	// authenticated by A256GCM: anyone holding the encryption key can
+	// issue tokens, so it must not be shared with services that should
+	// only read sessions.
+	JWEDirect
+)
+
+// JWEKey is a key JWTStore encrypts session tokens with.
+type JWEKey struct {
+	ID  string // written and matched as the kid header
+	Key []byte // 32 bytes for A256GCM
+}
+
+// jweHeader is the protected header of a compact JWE.
+type jweHeader struct {
+	Alg  string   `json:"alg"`
+	Enc  string   `json:"enc"`
+	Kid  string   `json:"kid,omitempty"`
+	Cty  string   `json:"cty,omitempty"`
+	Zip  string   `json:"zip,omitempty"`
+	Crit []string `json:"crit,omitempty"`
+}
+
+// NewJWEStore creates a JWTStore issuing direct encrypted tokens, see
+// JWEDirect. The first key encrypts; the others still decrypt the tokens
+// carrying their kid.
+func NewJWEStore(keys ...JWEKey) *JWTStore {
+	s := NewJWTStore(nil)
+	s.EncryptionKeys = keys
+	s.Encryption = JWEDirect
+	return s
+}
+
+// encode turns the claims of a token into the token handed to the client.
+func (s *JWTStore) encode(claims jwt.MapClaims) (string, error) {
+	if len(s.EncryptionKeys) > 0 && s.Encryption == JWEDirect {
+		payload, err := json.Marshal(claims)
+		if err != nil {
+			return "", err
+		}
+		return s.encrypt(payload, "")
+	}
+
+	key, err := s.signingKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(s.SigningMethod, claims)
+	if s.KeyID != "" {
+		token.Header["kid"] = s.KeyID
+	}
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		return "", err
+	}
+	if len(s.EncryptionKeys) > 0 {
+		return s.encrypt([]byte(tokenString), "JWT")
+	}
+	return tokenString, nil
+}
+
+// decode verifies a token from the client and returns its claims. Signed
+// tokens are still accepted by encrypting stores holding their key, so
+// sessions survive enabling encryption.
+func (s *JWTStore) decode(tokenString string) (jwt.MapClaims, error) {
+	if len(s.EncryptionKeys) > 0 && strings.Count(tokenString, ".") == 4 {
+		payload, err := s.decrypt(tokenString)
+		if err != nil {
+			return nil, err
+		}
+		if s.Encryption == JWEDirect {
+			claims := jwt.MapClaims{}
+			if err := json.Unmarshal(payload, &claims); err != nil {
+				return nil, errJWEMalformed
+			}
+			if err := jwt.NewValidator(s.parserOptions()...).Validate(claims); err != nil {
+				return nil, err
+			}
+			return claims, nil
+		}
+		tokenString = string(payload)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(tokenString, claims, s.keyFunc, s.parserOptions()...); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// encrypt seals payload into a compact JWE (RFC 7516) with alg dir and enc
+// A256GCM under the first encryption key.
+func (s *JWTStore) encrypt(payload []byte, cty string) (string, error) {
+	key := s.EncryptionKeys[0]
+	aead, err := newJWECipher(key.Key)
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(jweHeader{Alg: "dir", Enc: "A256GCM", Kid: key.ID, Cty: cty})
+	if err != nil {
+		return "", err
+	}
+	protected := b64(header)
+	iv := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	// The protected header is authenticated as the additional data.
+	sealed := aead.Seal(nil, iv, payload, []byte(protected))
+	n := len(sealed) - aead.Overhead()
+	return protected + ".." + b64(iv) + "." + b64(sealed[:n]) + "." + b64(sealed[n:]), nil
+}
+
+// decrypt opens a compact JWE with the encryption key named by its kid.
+func (s *JWTStore) decrypt(token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, errJWEMalformed
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errJWEMalformed
+	}
+	var header jweHeader
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, errJWEMalformed
+	}
+	// dir has no encrypted key, and compression and critical extensions
+	// are not supported.
+	if header.Alg != "dir" || header.Enc != "A256GCM" || header.Zip != "" || len(header.Crit) > 0 || parts[1] != "" {
+		return nil, errJWEAlgorithm
+	}
+	key, ok := s.encryptionKey(header.Kid)
+	if !ok {
+		return nil, errJWEUnknownKey
+	}
+	aead, err := newJWECipher(key)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(iv) != aead.NonceSize() {
+		return nil, errJWEMalformed
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, errJWEMalformed
+	}
+	tag, err := base64.RawURLEncoding.DecodeString(parts[4])
+	if err != nil || len(tag) != aead.Overhead() {
+		return nil, errJWEMalformed
+	}
+	payload, err := aead.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return nil, errJWEDecrypt
+	}
+	return payload, nil
+}
+
+// encryptionKey returns the encryption key with ID kid.
+func (s *JWTStore) encryptionKey(kid string) ([]byte, bool) {
+	for _, key := range s.EncryptionKeys {
+		if key.ID == kid {
+			return key.Key, true
+		}
+	}
+	return nil, false
+}
+
+func newJWECipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errJWEKeySize
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,88 @@
+package cartsess
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestJWTStore_Encrypted(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	nested := NewJWTStore([]byte("test-secret-key"))
+	nested.EncryptionKeys = []JWEKey{{ID: "e1", Key: key}}
+	direct := NewJWEStore(JWEKey{ID: "e1", Key: key})
+
+	for name, store := range map[string]*JWTStore{"nested": nested, "direct": direct} {
+		token := issueJWT(t, store)
+		parts := strings.Split(token, ".")
+		if len(parts) != 5 || parts[1] != "" {
+			t.Fatalf("%s: expected a compact dir JWE, got %q", name, token)
+		}
+		header, _ := base64.RawURLEncoding.DecodeString(parts[0])
+		if !strings.Contains(string(header), `"kid":"e1"`) {
+			t.Errorf("%s: expected kid in header, got %s", name, header)
+		}
+		if strings.Contains(token, "gopher") || strings.Contains(token, base64.RawURLEncoding.EncodeToString([]byte("gopher"))) {
+			t.Errorf("%s: session values are readable in %q", name, token)
+		}
+
+		session, err := loadJWT(store, token)
+		if err != nil {
+			t.Fatalf("%s: failed to load: %v", name, err)
+		}
+		if session.IsNew || session.Values["user"] != "gopher" {
+			t.Errorf("%s: expected values to round-trip, got %v", name, session.Values)
+		}
+
+		// Any change to the token fails authentication.
+		tampered := parts[0] + ".." + parts[2] + "." + flipFirst(parts[3]) + "." + parts[4]
+		if _, err := loadJWT(store, tampered); err == nil {
+			t.Errorf("%s: expected a tampered token to be rejected", name)
+		}
+	}
+
+	// The claims of direct tokens are still validated.
+	token := issueJWT(t, direct)
+	direct.Issuer = "https://shop.example"
+	if _, err := loadJWT(direct, token); err == nil {
+		t.Error("expected a direct token without iss to be rejected")
+	}
+}
+
+func TestJWTStore_EncryptionKeyRotation(t *testing.T) {
+	oldKey := JWEKey{ID: "e1", Key: bytes.Repeat([]byte{1}, 32)}
+	newKey := JWEKey{ID: "e2", Key: bytes.Repeat([]byte{2}, 32)}
+
+	store := NewJWTStore([]byte("test-secret-key"))
+	signed := issueJWT(t, store)
+	store.EncryptionKeys = []JWEKey{oldKey}
+	old := issueJWT(t, store)
+
+	store.EncryptionKeys = []JWEKey{newKey, oldKey}
+	for name, token := range map[string]string{"old key": old, "signed": signed} {
+		if _, err := loadJWT(store, token); err != nil {
+			t.Errorf("expected %s token to load: %v", name, err)
+		}
+	}
+
+	store.EncryptionKeys = []JWEKey{newKey}
+	if _, err := loadJWT(store, old); err == nil {
+		t.Error("expected tokens of a removed key to be rejected")
+	}
+
+	// A nested store never accepts direct tokens, which lack a signature.
+	direct := issueJWT(t, NewJWEStore(newKey))
+	if _, err := loadJWT(store, direct); err == nil {
+		t.Error("expected a direct token to be rejected by a nested store")
+	}
+}
+
+// flipFirst changes the first character of a base64url string.
+func flipFirst(s string) string {
+	if strings.HasPrefix(s, "A") {
+		return "B" + s[1:]
+	}
+	return "A" + s[1:]
+}
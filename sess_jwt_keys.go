@@ -0,0 +1,265 @@
+package cartsess
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	errJWTNoSigningKey = errors.New("jwt store has no signing key")
+	errJWTUnknownKey   = errors.New("unknown jwt key id")
+	errJWTMethod       = errors.New("invalid signing method")
+)
+
+// JWTKey is a key JWTStore accepts session tokens from.
+type JWTKey struct {
+	ID     string            // matched against the kid header; empty for tokens without one
+	Method jwt.SigningMethod // the only algorithm accepted with Key
+	// Key verifies tokens: []byte for HMAC, *rsa.PublicKey,
+	// *ecdsa.PublicKey or ed25519.PublicKey.
+	Key interface{}
+}
+
+// NewJWTStoreWithSigner creates a JWTStore signing tokens with an RSA,
+// ECDSA or Ed25519 private key, with kid in their header. Services holding
+// only the public key can verify them, see JWKSHandler.
+func NewJWTStoreWithSigner(method jwt.SigningMethod, key crypto.Signer, kid string) *JWTStore {
+	s := NewJWTStore(nil)
+	s.SigningMethod = method
+	s.PrivateKey = key
+	s.KeyID = kid
+	return s
+}
+
+// NewJWTVerifier creates a JWTStore that loads sessions from tokens
+// signed with any of keys, but cannot issue tokens itself.
+func NewJWTVerifier(keys ...JWTKey) *JWTStore {
+	s := NewJWTStore(nil)
+	s.SigningMethod = nil
+	s.VerificationKeys = keys
+	return s
+}
+
+// signingKey returns the key tokens are signed with.
+func (s *JWTStore) signingKey() (interface{}, error) {
+	switch {
+	case s.SigningMethod == nil:
+		return nil, errJWTNoSigningKey
+	case s.PrivateKey != nil:
+		return s.PrivateKey, nil
+	case len(s.SigningKey) > 0:
+		return s.SigningKey, nil
+	}
+	return nil, errJWTNoSigningKey
+}
+
+// ownKey returns the key verifying the tokens the store signs.
+func (s *JWTStore) ownKey() (JWTKey, bool) {
+	if s.SigningMethod == nil {
+		return JWTKey{}, false
+	}
+	if s.PrivateKey != nil {
+		return JWTKey{ID: s.KeyID, Method: s.SigningMethod, Key: s.PrivateKey.Public()}, true
+	}
+	if len(s.SigningKey) > 0 {
+		return JWTKey{ID: s.KeyID, Method: s.SigningMethod, Key: s.SigningKey}, true
+	}
+	return JWTKey{}, false
+}
+
+// keyFunc selects the verification keys by the kid header of the token.
+// Tokens without kid, such as those issued before KeyID was set, are
+// checked against the signing key and the verification keys without ID.
+// The algorithm must match the key's.
+func (s *JWTStore) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	var candidates []JWTKey
+	if own, ok := s.ownKey(); ok && (own.ID == kid || kid == "") {
+		candidates = append(candidates, own)
+	}
+	for _, key := range s.VerificationKeys {
+		if key.ID == kid {
+			candidates = append(candidates, key)
+		}
+	}
+	if len(candidates) == 0 && kid != "" {
+		return nil, errJWTUnknownKey
+	}
+	var set jwt.VerificationKeySet
+	for _, key := range candidates {
+		if key.Method != nil && key.Method.Alg() == token.Method.Alg() {
+			set.Keys = append(set.Keys, key.Key)
+		}
+	}
+	switch len(set.Keys) {
+	case 0:
+		return nil, errJWTMethod
+	case 1:
+		return set.Keys[0], nil
+	}
+	return set, nil
+}
+
+// JWK is a JSON Web Key (RFC 7517) holding a public key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the store: its signing key and its
+// verification keys. HMAC keys are secret and never included.
+func (s *JWTStore) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	keys := s.VerificationKeys
+	if own, ok := s.ownKey(); ok {
+		keys = append([]JWTKey{own}, keys...)
+	}
+	for _, key := range keys {
+		if jwk, ok := newJWK(key); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+// JWKSHandler serves the public keys of the store as a JWKS document, for
+// instance at /.well-known/jwks.json.
+func (s *JWTStore) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(s.JWKS())
+	})
+}
+
+func newJWK(key JWTKey) (JWK, bool) {
+	jwk := JWK{Kid: key.ID, Use: "sig"}
+	if key.Method != nil {
+		jwk.Alg = key.Method.Alg()
+	}
+	switch k := key.Key.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(k.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = k.Curve.Params().Name
+		jwk.X = b64(k.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(k.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(k)
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseJWKS parses a JWKS document, such as one served by JWKSHandler,
+// into verification keys. Keys without alg are skipped.
+func ParseJWKS(data []byte) ([]JWTKey, error) {
+	var set JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	var keys []JWTKey
+	for _, jwk := range set.Keys {
+		if jwk.Alg == "" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwk %q: %w", jwk.Kid, err)
+		}
+		method := jwt.GetSigningMethod(jwk.Alg)
+		if method == nil {
+			return nil, fmt.Errorf("jwk %q: unknown algorithm %q", jwk.Kid, jwk.Alg)
+		}
+		keys = append(keys, JWTKey{ID: jwk.Kid, Method: method, Key: key})
+	}
+	return keys, nil
+}
+
+// PublicKey decodes the public key of the JWK.
+func (k JWK) PublicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if _, err := key.ECDH(); err != nil {
+			return nil, err
+		}
+		return key, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
@@ -0,0 +1,104 @@
+package cartsess
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// issueJWT saves a session with store and returns its token.
+func issueJWT(t *testing.T, store *JWTStore) string {
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "jwt-session")
+	session.Values["user"] = "gopher"
+	if err := session.Save(req, rec); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	return rec.Header().Get("X-JWT-Token")
+}
+
+// loadJWT loads the session of token with store.
+func loadJWT(store *JWTStore, token string) (*Session, error) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return store.Get(req, "jwt-session")
+}
+
+func TestJWTStore_Asymmetric(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	for _, tt := range []struct {
+		method jwt.SigningMethod
+		key    crypto.Signer
+	}{
+		{jwt.SigningMethodRS256, rsaKey},
+		{jwt.SigningMethodES256, ecKey},
+		{jwt.SigningMethodEdDSA, edKey},
+	} {
+		alg := tt.method.Alg()
+		issuer := NewJWTStoreWithSigner(tt.method, tt.key, "k-"+alg)
+		token := issueJWT(t, issuer)
+
+		// Another service verifies with the published public key only.
+		rec := httptest.NewRecorder()
+		issuer.JWKSHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+		keys, err := ParseJWKS(rec.Body.Bytes())
+		if err != nil || len(keys) != 1 || keys[0].ID != "k-"+alg {
+			t.Fatalf("%s: unexpected JWKS %s: %v", alg, rec.Body, err)
+		}
+		verifier := NewJWTVerifier(keys...)
+		session, err := loadJWT(verifier, token)
+		if err != nil || session.Values["user"] != "gopher" {
+			t.Errorf("%s: failed to verify: %v", alg, err)
+		}
+		if err := session.Save(httptest.NewRequest("GET", "/", nil), httptest.NewRecorder()); err == nil {
+			t.Errorf("%s: expected a verifier not to issue tokens", alg)
+		}
+	}
+}
+
+func TestJWTStore_KeyIDs(t *testing.T) {
+	old := NewJWTStore([]byte("old-secret"))
+	old.KeyID = "old"
+	current := NewJWTStore([]byte("new-secret"))
+	current.KeyID = "new"
+	current.VerificationKeys = []JWTKey{{ID: "old", Method: jwt.SigningMethodHS256, Key: []byte("old-secret")}}
+
+	if _, err := loadJWT(current, issueJWT(t, old)); err != nil {
+		t.Errorf("expected token of a verification key to load: %v", err)
+	}
+
+	other := NewJWTStore([]byte("other-secret"))
+	other.KeyID = "other"
+	if _, err := loadJWT(current, issueJWT(t, other)); err == nil {
+		t.Error("expected token with unknown kid to be rejected")
+	}
+
+	// Tokens issued before kid was set still load.
+	legacy := NewJWTStore([]byte("new-secret"))
+	if _, err := loadJWT(current, issueJWT(t, legacy)); err != nil {
+		t.Errorf("expected token without kid to load: %v", err)
+	}
+}
+
+func TestJWTStore_AlgorithmConfusion(t *testing.T) {
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	store := NewJWTStoreWithSigner(jwt.SigningMethodEdDSA, edKey, "ed")
+
+	// An HS256 token keyed with the public key must not verify.
+	forged := NewJWTStore([]byte(edPub))
+	forged.KeyID = "ed"
+	if _, err := loadJWT(store, issueJWT(t, forged)); err == nil {
+		t.Error("expected HS256 token to be rejected by an EdDSA key")
+	}
+}
@@ -0,0 +1,361 @@
+package cartsess
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRefreshNotFound is returned for refresh tokens of unknown, expired
+	// or revoked families.
+	ErrRefreshNotFound = errors.New("refresh token not found")
+	// ErrRefreshReused is returned when a refresh token that was already
+	// rotated is presented again. The family is revoked, since either the
+	// client or an attacker holds a stolen token.
+	ErrRefreshReused = errors.New("refresh token reused")
+)
+
+// refreshCookieSuffix is appended to the session name to name the refresh
+// token cookie.
+const refreshCookieSuffix = "_refresh"
+
+// RefreshFamily is the chain of refresh tokens descending from one login.
+// Only the hashes of the current and the last rotated token are kept.
+type RefreshFamily struct {
+	ID           string
+	TokenHash    string
+	PreviousHash string                 // hash of the last rotated token, to detect its reuse
+	Values       map[string]interface{} // session values of the latest access token
+	CreatedAt    time.Time              // time of the login, checked against RevokeSubject
+	ExpiresAt    time.Time
+}
+
+// RefreshStore persists refresh token families for JWTStore.
+type RefreshStore interface {
+	// Create adds a new family.
+	Create(ctx context.Context, family *RefreshFamily) error
+	// Update replaces the session values of a family. It returns
+	// ErrRefreshNotFound if the family is gone.
+	Update(ctx context.Context, id string, values map[string]interface{}) error
+	// Rotate replaces the current token hash of a family with next and
+	// extends it to expires, returning the family. If oldHash is the hash
+	// of the last rotated token the family is revoked and ErrRefreshReused
+	// returned; any other hash returns ErrRefreshNotFound, so a forged
+	// secret for a known family ID can not revoke it.
+	Rotate(ctx context.Context, id, oldHash, nextHash string, expires time.Time) (*RefreshFamily, error)
+	// Revoke deletes a family.
+	Revoke(ctx context.Context, id string) error
+}
+
+// NewJWTStoreWithRefresh creates a JWTStore issuing access tokens valid for
+// 15 minutes, renewed through RefreshHandler with refresh tokens valid for
+// 30 days whose families are kept in families.
+func NewJWTStoreWithRefresh(signingKey []byte, families RefreshStore) *JWTStore {
+	s := NewJWTStore(signingKey)
+	s.Options.MaxAge = 15 * 60
+	s.Families = families
+	s.RefreshTTL = 30 * 24 * time.Hour
+	return s
+}
+
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshToken returns a refresh token of family id, and the hash of
+// its secret.
+func newRefreshToken(id string) (token, hash string) {
+	secret := newTokenID()
+	return id + "." + secret, hashRefreshSecret(secret)
+}
+
+func (s *JWTStore) refreshTTL() time.Duration {
+	if s.RefreshTTL > 0 {
+		return s.RefreshTTL
+	}
+	return 30 * 24 * time.Hour
+}
+
+// Login makes session start a refresh token family when it is next saved,
+// replacing the family it belonged to. Call it once the user has logged
+// in: other sessions only get access tokens, so anonymous visitors do not
+// fill the RefreshStore.
+func (s *JWTStore) Login(session *Session) {
+	session.startFamily = true
+	session.needsSave = true
+}
+
+// saveFamily records the values of session in its refresh family, or
+// starts a family, and hands out its first refresh token, after Login.
+// A session whose family expired or was revoked keeps its access token but
+// leaves the family.
+func (s *JWTStore) saveFamily(w http.ResponseWriter, session *Session) error {
+	ctx, cancel := Context()
+	defer cancel()
+	if !session.startFamily {
+		if session.tokenFamily == "" {
+			return nil
+		}
+		err := s.Families.Update(ctx, session.tokenFamily, session.Values)
+		if err == ErrRefreshNotFound {
+			session.tokenFamily = ""
+			return nil
+		}
+		return err
+	}
+	if session.tokenFamily != "" {
+		if err := s.Families.Revoke(ctx, session.tokenFamily); err != nil {
+			return err
+		}
+	}
+	id := newTokenID()
+	token, hash := newRefreshToken(id)
+	err := s.Families.Create(ctx, &RefreshFamily{
+		ID:        id,
+		TokenHash: hash,
+		Values:    copyValues(session.Values),
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(s.refreshTTL()),
+	})
+	if err != nil {
+		return err
+	}
+	session.tokenFamily = id
+	session.startFamily = false
+	s.setRefreshToken(w, session, token)
+	return nil
+}
+
+// setRefreshToken hands a refresh token to the client with the refresh
+// transport. Cookies are HttpOnly and limited to RefreshPath.
+func (s *JWTStore) setRefreshToken(w http.ResponseWriter, session *Session, token string) {
+	opts := *session.Options
+	opts.MaxAge = int(s.refreshTTL() / time.Second)
+	opts.HttpOnly = true
+	if s.RefreshPath != "" {
+		opts.Path = s.RefreshPath
+	}
+	if t := s.refreshTransport(); t != nil {
+		t.SetToken(w, session.CookieName()+refreshCookieSuffix, token, &opts)
+	}
+}
+
+// refreshTransport returns the transport of refresh tokens.
+func (s *JWTStore) refreshTransport() TokenTransport {
+	if s.RefreshTransport != nil {
+		return s.RefreshTransport
+	}
+	return refreshTransportFor(s.transport())
+}
+
+// revokeFamily revokes the refresh family of a destroyed session and
+// asks the client to forget its refresh token.
+func (s *JWTStore) revokeFamily(w http.ResponseWriter, session *Session) error {
+	var err error
+	if session.tokenFamily != "" {
+		ctx, cancel := Context()
+		defer cancel()
+		err = s.Families.Revoke(ctx, session.tokenFamily)
+	}
+	opt := &Options{
+		Path:     session.Options.Path,
+		Domain:   session.Options.Domain,
+		Secure:   session.Options.Secure,
+		HttpOnly: true,
+		MaxAge:   -1,
+	}
+	if s.RefreshPath != "" {
+		opt.Path = s.RefreshPath
+	}
+	if t := s.refreshTransport(); t != nil {
+		t.SetToken(w, session.CookieName()+refreshCookieSuffix, "", opt)
+	}
+	return err
+}
+
+// refreshToken reads the refresh token of session name from r with the
+// refresh transport.
+func (s *JWTStore) refreshToken(r *http.Request, name string) (string, error) {
+	t := s.refreshTransport()
+	if t == nil {
+		return "", nil
+	}
+	return t.Token(r, name+refreshCookieSuffix)
+}
+
+// Refresh exchanges the refresh token of the request for a new access and
+// refresh token pair, written to w like Save does. The presented refresh
+// token can not be used again.
+func (s *JWTStore) Refresh(r *http.Request, w http.ResponseWriter, name string) (*Session, error) {
+	session, _, _, err := s.refresh(r, w, name)
+	return session, err
+}
+
+// refresh implements Refresh, also returning the new access and refresh
+// tokens.
+func (s *JWTStore) refresh(r *http.Request, w http.ResponseWriter, name string) (*Session, string, string, error) {
+	if s.Families == nil {
+		return nil, "", "", ErrRefreshNotFound
+	}
+	presented, err := s.refreshToken(r, name)
+	if err != nil {
+		return nil, "", "", err
+	}
+	id, secret, ok := strings.Cut(presented, ".")
+	if !ok || id == "" || secret == "" {
+		return nil, "", "", ErrRefreshNotFound
+	}
+	token, hash := newRefreshToken(id)
+	ctx, cancel := Context()
+	defer cancel()
+	family, err := s.Families.Rotate(ctx, id, hashRefreshSecret(secret), hash, time.Now().Add(s.refreshTTL()))
+	if err != nil {
+		return nil, "", "", err
+	}
+	if s.Denylist != nil {
+		// RevokeSubject also ends the families of logins before it.
+		revoked, err := s.Denylist.IsRevoked(ctx, "", s.subject(family.Values), family.CreatedAt)
+		if err != nil {
+			return nil, "", "", err
+		}
+		if revoked {
+			s.Families.Revoke(ctx, id)
+			return nil, "", "", ErrTokenRevoked
+		}
+	}
+
+	session := NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.Values = family.Values
+	session.tokenFamily = id
+	access, err := s.issue(w, session)
+	if err != nil {
+		return nil, "", "", err
+	}
+	s.setRefreshToken(w, session, token)
+	return session, access, token, nil
+}
+
+// RefreshHandler returns the endpoint clients call with their refresh
+// token, for sessions named name. It answers with the new tokens as JSON
+// and in cookies, or 401 if the token is invalid, expired, revoked or
+// reused.
+func (s *JWTStore) RefreshHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		_, access, refresh, err := s.refresh(r, w, name)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  access,
+			"refresh_token": refresh,
+			"token_type":    "Bearer",
+			"expires_in":    s.Options.MaxAge,
+		})
+	})
+}
+
+// MemoryRefreshStore keeps refresh families in memory, for single
+// instance deployments and tests.
+type MemoryRefreshStore struct {
+	mutex     sync.Mutex
+	families  map[string]*RefreshFamily
+	lastPurge time.Time
+}
+
+var _ RefreshStore = &MemoryRefreshStore{}
+
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{families: make(map[string]*RefreshFamily)}
+}
+
+// get returns a live family, dropping it if expired. The mutex must be held.
+func (m *MemoryRefreshStore) get(id string) *RefreshFamily {
+	family := m.families[id]
+	if family != nil && time.Now().After(family.ExpiresAt) {
+		delete(m.families, id)
+		return nil
+	}
+	return family
+}
+
+// purge drops expired families, at most once a minute. The mutex must be
+// held.
+func (m *MemoryRefreshStore) purge(now time.Time) {
+	if now.Sub(m.lastPurge) < time.Minute {
+		return
+	}
+	m.lastPurge = now
+	for id, family := range m.families {
+		if now.After(family.ExpiresAt) {
+			delete(m.families, id)
+		}
+	}
+}
+
+func (m *MemoryRefreshStore) Create(ctx context.Context, family *RefreshFamily) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.purge(time.Now())
+	f := *family
+	m.families[f.ID] = &f
+	return nil
+}
+
+func (m *MemoryRefreshStore) Update(ctx context.Context, id string, values map[string]interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	family := m.get(id)
+	if family == nil {
+		return ErrRefreshNotFound
+	}
+	family.Values = copyValues(values)
+	return nil
+}
+
+func (m *MemoryRefreshStore) Rotate(ctx context.Context, id, oldHash, nextHash string, expires time.Time) (*RefreshFamily, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	family := m.get(id)
+	if family == nil {
+		return nil, ErrRefreshNotFound
+	}
+	if subtle.ConstantTimeCompare([]byte(family.TokenHash), []byte(oldHash)) != 1 {
+		if family.PreviousHash != "" && subtle.ConstantTimeCompare([]byte(family.PreviousHash), []byte(oldHash)) == 1 {
+			delete(m.families, id)
+			return nil, ErrRefreshReused
+		}
+		return nil, ErrRefreshNotFound
+	}
+	family.PreviousHash = family.TokenHash
+	family.TokenHash = nextHash
+	family.ExpiresAt = expires
+	f := *family
+	f.Values = copyValues(family.Values)
+	return &f, nil
+}
+
+func (m *MemoryRefreshStore) Revoke(ctx context.Context, id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.families, id)
+	return nil
+}
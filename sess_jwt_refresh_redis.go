@@ -0,0 +1,140 @@
+package cartsess
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// refreshKeyPrefix is inserted between Prefix and the family ID in the
+// keys of refresh families.
+const refreshKeyPrefix = "refresh:"
+
+// Fields of the redis hash of a refresh family.
+const (
+	refreshHashField     = "h"
+	refreshPreviousField = "p"
+	refreshValuesField   = "v"
+	refreshCreatedField  = "c" // unix milliseconds
+)
+
+// rotateScript swaps the token hash of a family if ARGV[1] is current,
+// returning the serialized values and creation time. It deletes the family
+// if ARGV[1] is the hash of the last rotated token, and returns 0 for a
+// reused token and false for an unknown one.
+var rotateScript = redis.NewScript(`
+local hashes = redis.call('HMGET', KEYS[1], 'h', 'p')
+if not hashes[1] then
+	return false
+end
+if hashes[1] ~= ARGV[1] then
+	if hashes[2] and hashes[2] == ARGV[1] then
+		redis.call('DEL', KEYS[1])
+		return 0
+	end
+	return false
+end
+redis.call('HSET', KEYS[1], 'h', ARGV[2], 'p', ARGV[1])
+redis.call('PEXPIREAT', KEYS[1], ARGV[3])
+return redis.call('HMGET', KEYS[1], 'v', 'c')
+`)
+
+// updateScript replaces the values of a family if it still exists.
+var updateScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'v', ARGV[1])
+return 1
+`)
+
+// RedisRefreshStore keeps refresh families in redis hashes that expire
+// with the family. Rotation runs as a script, so a token can only be
+// exchanged once even with concurrent requests.
+type RedisRefreshStore struct {
+	Client     redis.UniversalClient
+	Prefix     string
+	Serializer Serializer // serializes family values (default JSONEncoder)
+}
+
+var _ RefreshStore = &RedisRefreshStore{}
+
+func NewRedisRefreshStore(client redis.UniversalClient) *RedisRefreshStore {
+	return &RedisRefreshStore{
+		Client:     client,
+		Serializer: JSONEncoder{},
+	}
+}
+
+func (s *RedisRefreshStore) key(id string) string {
+	return s.Prefix + refreshKeyPrefix + id
+}
+
+func (s *RedisRefreshStore) Create(ctx context.Context, family *RefreshFamily) error {
+	b, err := s.Serializer.Serialize(family.Values)
+	if err != nil {
+		return err
+	}
+	key := s.key(family.ID)
+	_, err = s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, refreshHashField, family.TokenHash, refreshPreviousField, family.PreviousHash,
+			refreshValuesField, b, refreshCreatedField, family.CreatedAt.UnixMilli())
+		pipe.PExpireAt(ctx, key, family.ExpiresAt)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisRefreshStore) Update(ctx context.Context, id string, values map[string]interface{}) error {
+	b, err := s.Serializer.Serialize(values)
+	if err != nil {
+		return err
+	}
+	n, err := updateScript.Run(ctx, s.Client, []string{s.key(id)}, b).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRefreshNotFound
+	}
+	return nil
+}
+
+func (s *RedisRefreshStore) Rotate(ctx context.Context, id, oldHash, nextHash string, expires time.Time) (*RefreshFamily, error) {
+	res, err := rotateScript.Run(ctx, s.Client, []string{s.key(id)}, oldHash, nextHash, expires.UnixMilli()).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrRefreshNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := res.([]interface{})
+	if !ok || len(fields) != 2 {
+		return nil, ErrRefreshReused
+	}
+	raw, _ := fields[0].(string)
+	family := &RefreshFamily{
+		ID:           id,
+		TokenHash:    nextHash,
+		PreviousHash: oldHash,
+		Values:       make(map[string]interface{}),
+		ExpiresAt:    expires,
+	}
+	// Families created before the field was written keep a zero creation
+	// time, so any RevokeSubject ends them.
+	if created, _ := fields[1].(string); created != "" {
+		ms, _ := strconv.ParseInt(created, 10, 64)
+		family.CreatedAt = time.UnixMilli(ms)
+	}
+	if err := s.Serializer.Deserialize([]byte(raw), &family.Values); err != nil {
+		return nil, err
+	}
+	return family, nil
+}
+
+func (s *RedisRefreshStore) Revoke(ctx context.Context, id string) error {
+	return s.Client.Del(ctx, s.key(id)).Err()
+}
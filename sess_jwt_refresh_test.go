@@ -0,0 +1,224 @@
+package cartsess
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// refresh calls the refresh endpoint of store with token.
+func refresh(store *JWTStore, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/refresh", nil)
+	req.Header.Set("X-Refresh-Token", token)
+	rec := httptest.NewRecorder()
+	store.RefreshHandler("jwt-session").ServeHTTP(rec, req)
+	return rec
+}
+
+func TestJWTStore_Refresh(t *testing.T) {
+	store := NewJWTStoreWithRefresh([]byte("test-secret-key"), NewMemoryRefreshStore())
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "jwt-session")
+	session.Values["user"] = "gopher"
+	store.Login(session)
+	if err := session.Save(req, rec); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	access := rec.Header().Get("X-JWT-Token")
+	first := rec.Header().Get("X-Refresh-Token")
+	if first == "" {
+		t.Fatal("expected a refresh token")
+	}
+
+	// Changes to the session are carried over to refreshed tokens.
+	loaded, err := loadJWT(store, access)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	loaded.Values["cart"] = "apple"
+	rec = httptest.NewRecorder()
+	if err := loaded.Save(req, rec); err != nil {
+		t.Fatalf("failed to save loaded session: %v", err)
+	}
+	if rec.Header().Get("X-Refresh-Token") != "" {
+		t.Error("expected no new refresh token for an existing family")
+	}
+
+	rec = refresh(store, first)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected refresh to succeed, got %d", rec.Code)
+	}
+	var body struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	json.NewDecoder(rec.Body).Decode(&body)
+	if body.RefreshToken == "" || body.RefreshToken == first {
+		t.Fatalf("expected a rotated refresh token, got %q", body.RefreshToken)
+	}
+	refreshed, err := loadJWT(store, body.AccessToken)
+	if err != nil || refreshed.Values["user"] != "gopher" || refreshed.Values["cart"] != "apple" {
+		t.Fatalf("unexpected refreshed session %v: %v", refreshed.Values, err)
+	}
+
+	// Reusing the first token revokes the family, so the current token
+	// stops working as well.
+	if rec := refresh(store, first); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected reused token to be rejected, got %d", rec.Code)
+	}
+	if rec := refresh(store, body.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected family to be revoked, got %d", rec.Code)
+	}
+}
+
+func TestJWTStore_RefreshForgedSecret(t *testing.T) {
+	store := NewJWTStoreWithRefresh([]byte("test-secret-key"), NewMemoryRefreshStore())
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "jwt-session")
+	session.Values["user"] = "gopher"
+	store.Login(session)
+	session.Save(req, rec)
+	token := rec.Header().Get("X-Refresh-Token")
+
+	// The family ID can be read from any access token.
+	loaded, _ := loadJWT(store, rec.Header().Get("X-JWT-Token"))
+	if rec := refresh(store, loaded.tokenFamily+".forged"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected forged token to be rejected, got %d", rec.Code)
+	}
+	if rec := refresh(store, token); rec.Code != http.StatusOK {
+		t.Errorf("expected forged token not to revoke the family, got %d", rec.Code)
+	}
+}
+
+func TestJWTStore_RefreshRevokedOnDestroy(t *testing.T) {
+	store := NewJWTStoreWithRefresh([]byte("test-secret-key"), NewMemoryRefreshStore())
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "jwt-session")
+	session.Values["user"] = "gopher"
+	store.Login(session)
+	session.Save(req, rec)
+	token := rec.Header().Get("X-Refresh-Token")
+
+	loaded, _ := loadJWT(store, rec.Header().Get("X-JWT-Token"))
+	if err := loaded.Destroy(req, httptest.NewRecorder()); err != nil {
+		t.Fatalf("failed to destroy: %v", err)
+	}
+	if rec := refresh(store, token); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected refresh after logout to fail, got %d", rec.Code)
+	}
+	// Writes to a session whose family is gone still get an access token,
+	// without a family.
+	loaded.Values["cart"] = "apple"
+	rec = httptest.NewRecorder()
+	if err := loaded.Save(req, rec); err != nil {
+		t.Fatalf("expected session without family to be saved, got %v", err)
+	}
+	if loaded.tokenFamily != "" || rec.Header().Get("X-Refresh-Token") != "" {
+		t.Error("expected the session to leave its revoked family")
+	}
+	saved, err := loadJWT(store, rec.Header().Get("X-JWT-Token"))
+	if err != nil || saved.Values["cart"] != "apple" {
+		t.Errorf("unexpected saved session %v: %v", saved, err)
+	}
+}
+
+func TestJWTStore_RefreshOnlyAfterLogin(t *testing.T) {
+	families := NewMemoryRefreshStore()
+	store := NewJWTStoreWithRefresh([]byte("test-secret-key"), families)
+
+	// Anonymous sessions get an access token only.
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "jwt-session")
+	session.Values["cart"] = "apple"
+	session.Save(req, rec)
+	if rec.Header().Get("X-Refresh-Token") != "" || len(families.families) != 0 {
+		t.Fatal("expected no refresh family for an anonymous session")
+	}
+
+	// Logging in starts a family, and logging in again replaces it.
+	store.Login(session)
+	session.Save(req, httptest.NewRecorder())
+	first := session.tokenFamily
+	store.Login(session)
+	session.Save(req, httptest.NewRecorder())
+	if first == "" || session.tokenFamily == first || len(families.families) != 1 {
+		t.Errorf("expected one family replacing %q, got %v", first, families.families)
+	}
+}
+
+func TestMemoryRefreshStore_Purge(t *testing.T) {
+	families := NewMemoryRefreshStore()
+	ctx := context.Background()
+	families.Create(ctx, &RefreshFamily{ID: "old", ExpiresAt: time.Now().Add(-time.Second)})
+
+	families.lastPurge = time.Time{}
+	families.Create(ctx, &RefreshFamily{ID: "new", ExpiresAt: time.Now().Add(time.Hour)})
+	if _, ok := families.families["old"]; ok || len(families.families) != 1 {
+		t.Errorf("expected expired families to be purged, got %v", families.families)
+	}
+}
+
+func TestJWTStore_RefreshAfterRevokeSubject(t *testing.T) {
+	store := NewJWTStoreWithRefresh([]byte("test-secret-key"), NewMemoryRefreshStore())
+	store.Denylist = NewMemoryDenylist()
+	store.SubjectKey = "user_id"
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "jwt-session")
+	session.Values["user_id"] = 42
+	store.Login(session)
+	session.Save(req, rec)
+	token := rec.Header().Get("X-Refresh-Token")
+
+	if err := store.RevokeSubject(context.Background(), "42", time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("failed to revoke: %v", err)
+	}
+	if rec := refresh(store, token); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected refresh after RevokeSubject to fail, got %d", rec.Code)
+	}
+	if _, ok := store.Families.(*MemoryRefreshStore).families[session.tokenFamily]; ok {
+		t.Error("expected the family to be revoked")
+	}
+}
+
+func TestRedisRefreshStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	families := NewRedisRefreshStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	ctx := context.Background()
+	created := time.Now().Truncate(time.Millisecond)
+	families.Create(ctx, &RefreshFamily{
+		ID:        "f1",
+		TokenHash: "h1",
+		Values:    map[string]interface{}{"user_id": "42"},
+		CreatedAt: created,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+
+	family, err := families.Rotate(ctx, "f1", "h1", "h2", time.Now().Add(time.Hour))
+	if err != nil || family.Values["user_id"] != "42" || !family.CreatedAt.Equal(created) {
+		t.Fatalf("unexpected family %+v, %v", family, err)
+	}
+	if _, err := families.Rotate(ctx, "f1", "forged", "h3", time.Now().Add(time.Hour)); err != ErrRefreshNotFound {
+		t.Errorf("expected ErrRefreshNotFound for an unknown hash, got %v", err)
+	}
+	if _, err := families.Rotate(ctx, "f1", "h1", "h3", time.Now().Add(time.Hour)); err != ErrRefreshReused {
+		t.Errorf("expected ErrRefreshReused, got %v", err)
+	}
+	if _, err := families.Rotate(ctx, "f1", "h2", "h3", time.Now().Add(time.Hour)); err != ErrRefreshNotFound {
+		t.Errorf("expected the reused family to be gone, got %v", err)
+	}
+}
@@ -15,6 +15,7 @@ type MemoryStore struct {
 	gc              map[string]int64       //session gc time store
 	SessionIDLength int
 	GCTime          time.Duration //ever second run GC
+	Serializer      Serializer    // optional, store sessions serialized instead of as live values
 }
 
 var _ Store = &MemoryStore{}
@@ -52,8 +53,15 @@ func (s *MemoryStore) New(r *http.Request, cookieName string) (*Session, error)
 	if sid, errCookie := r.Cookie(cookieName); errCookie == nil {
 		session.ID = sid.Value
 		//get value
-		if s.value[sid.Value] != nil {
-			session.Values = s.value[sid.Value].(map[string]interface{})
+		if stored := s.value[sid.Value]; stored != nil {
+			if b, ok := stored.([]byte); ok {
+				err = s.Serializer.Deserialize(b, session)
+			} else {
+				session.Values = stored.(map[string]interface{})
+			}
+			session.IsNew = false
+		} else {
+			session.ID = generateID(s.SessionIDLength)
 		}
 	} else {
 		newid := generateID(s.SessionIDLength)
@@ -68,7 +76,15 @@ func (s *MemoryStore) Save(r *http.Request, w http.ResponseWriter, session *Sess
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	sid := session.ID
-	s.value[sid] = session.Values
+	if s.Serializer != nil {
+		b, err := s.Serializer.Serialize(session)
+		if err != nil {
+			return err
+		}
+		s.value[sid] = b
+	} else {
+		s.value[sid] = session.Values
+	}
 	s.gc[sid] = time.Now().Unix()
 
 	cookie := NewCookie(session.CookieName(), session.ID, session.Options)
@@ -0,0 +1,41 @@
+package cartsess
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMemoryStore_New(t *testing.T) {
+	store := NewMemoryStore()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "sess")
+	if !session.IsNew {
+		t.Error("expected a session without cookie to be new")
+	}
+	session.Values["user"] = "alice"
+	if err := session.Save(req, rec); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	// A stored session is loaded, not new.
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(rec.Result().Cookies()[0])
+	loaded, err := store.Get(req, "sess")
+	if err != nil || loaded.IsNew || loaded.ID != session.ID {
+		t.Fatalf("expected the stored session, got %+v, %v", loaded, err)
+	}
+	if loaded.Values["user"] != "alice" {
+		t.Errorf("expected user 'alice', got %v", loaded.Values["user"])
+	}
+
+	// An unknown ID is not adopted: the client cannot choose its session ID.
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "sess", Value: "chosen-by-client"})
+	fresh, _ := store.Get(req, "sess")
+	if !fresh.IsNew || fresh.ID == "chosen-by-client" {
+		t.Errorf("expected a new session with a generated ID, got %q", fresh.ID)
+	}
+}
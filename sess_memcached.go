@@ -0,0 +1,365 @@
+package cartsess
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCASConflict is returned by MemcachedStore.Save when the session was
+	// modified by another request since it was loaded.
+	ErrCASConflict = fmt.Errorf("cas conflict")
+	// ErrNotStored is returned when memcached refuses to store an item.
+	ErrNotStored = fmt.Errorf("not stored")
+)
+
+// memcachedRelativeLimit is the largest expiration memcached treats as a
+// relative number of seconds. Larger values are taken as a unix timestamp.
+const memcachedRelativeLimit = 60 * 60 * 24 * 30
+
+// MemcachedClient is a minimal memcached client speaking the text protocol.
+type MemcachedClient struct {
+	Addr         string
+	Timeout      time.Duration
+	MaxIdleConns int
+
+	mutex sync.Mutex
+	idle  []net.Conn
+}
+
+// NewMemcachedClient creates a client for the memcached server at addr.
+func NewMemcachedClient(addr string) *MemcachedClient {
+	return &MemcachedClient{
+		Addr:         addr,
+		Timeout:      5 * time.Second,
+		MaxIdleConns: 2,
+	}
+}
+
+func (c *MemcachedClient) conn() (net.Conn, error) {
+	c.mutex.Lock()
+	if n := len(c.idle); n > 0 {
+		nc := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mutex.Unlock()
+		return nc, nil
+	}
+	c.mutex.Unlock()
+	return net.DialTimeout("tcp", c.Addr, c.Timeout)
+}
+
+func (c *MemcachedClient) release(nc net.Conn, err error) {
+	// Protocol errors leave the stream in an unknown state, so only
+	// connections that completed a round trip cleanly are reused.
+	if err != nil && err != ErrNotFound && err != ErrNotStored && err != ErrCASConflict {
+		nc.Close()
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.idle) >= c.MaxIdleConns {
+		nc.Close()
+		return
+	}
+	c.idle = append(c.idle, nc)
+}
+
+func (c *MemcachedClient) do(fn func(rw *bufio.ReadWriter) error) error {
+	nc, err := c.conn()
+	if err != nil {
+		return err
+	}
+	if c.Timeout > 0 {
+		nc.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+	err = fn(rw)
+	c.release(nc, err)
+	return err
+}
+
+// Gets fetches the item stored under key together with its cas token.
+// It returns ErrNotFound when the key does not exist.
+func (c *MemcachedClient) Gets(key string) (value []byte, cas uint64, err error) {
+	if err = checkMemcachedKey(key); err != nil {
+		return nil, 0, err
+	}
+	err = c.do(func(rw *bufio.ReadWriter) error {
+		if _, err := fmt.Fprintf(rw, "gets %s\r\n", key); err != nil {
+			return err
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		found := false
+		for {
+			line, err := rw.ReadSlice('\n')
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(line, []byte("END\r\n")) {
+				break
+			}
+			// VALUE <key> <flags> <bytes> <cas unique>
+			fields := strings.Fields(string(line))
+			if len(fields) != 5 || fields[0] != "VALUE" {
+				return fmt.Errorf("memcached: unexpected response %q", line)
+			}
+			size, err := strconv.Atoi(fields[3])
+			if err != nil {
+				return fmt.Errorf("memcached: bad item size %q", fields[3])
+			}
+			if cas, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
+				return fmt.Errorf("memcached: bad cas token %q", fields[4])
+			}
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, buf); err != nil {
+				return err
+			}
+			value = buf[:size]
+			found = true
+		}
+		if !found {
+			return ErrNotFound
+		}
+		return nil
+	})
+	return value, cas, err
+}
+
+// Set unconditionally stores value under key.
+func (c *MemcachedClient) Set(key string, value []byte, exptime int64) error {
+	return c.store("set", key, value, exptime, 0)
+}
+
+// Add stores value under key only if the key does not already exist.
+func (c *MemcachedClient) Add(key string, value []byte, exptime int64) error {
+	return c.store("add", key, value, exptime, 0)
+}
+
+// CompareAndSwap stores value under key only if the item has not been
+// modified since cas was obtained with Gets. It returns ErrCASConflict when
+// the item was modified and ErrNotFound when it no longer exists.
+func (c *MemcachedClient) CompareAndSwap(key string, value []byte, exptime int64, cas uint64) error {
+	return c.store("cas", key, value, exptime, cas)
+}
+
+func (c *MemcachedClient) store(verb, key string, value []byte, exptime int64, cas uint64) error {
+	if err := checkMemcachedKey(key); err != nil {
+		return err
+	}
+	return c.do(func(rw *bufio.ReadWriter) error {
+		var err error
+		if verb == "cas" {
+			_, err = fmt.Fprintf(rw, "cas %s 0 %d %d %d\r\n", key, exptime, len(value), cas)
+		} else {
+			_, err = fmt.Fprintf(rw, "%s %s 0 %d %d\r\n", verb, key, exptime, len(value))
+		}
+		if err != nil {
+			return err
+		}
+		rw.Write(value)
+		rw.WriteString("\r\n")
+		if err = rw.Flush(); err != nil {
+			return err
+		}
+		line, err := rw.ReadSlice('\n')
+		if err != nil {
+			return err
+		}
+		switch string(line) {
+		case "STORED\r\n":
+			return nil
+		case "NOT_STORED\r\n":
+			return ErrNotStored
+		case "EXISTS\r\n":
+			return ErrCASConflict
+		case "NOT_FOUND\r\n":
+			return ErrNotFound
+		}
+		return fmt.Errorf("memcached: unexpected response %q", line)
+	})
+}
+
+// Delete removes key. It returns ErrNotFound when the key does not exist.
+func (c *MemcachedClient) Delete(key string) error {
+	if err := checkMemcachedKey(key); err != nil {
+		return err
+	}
+	return c.do(func(rw *bufio.ReadWriter) error {
+		if _, err := fmt.Fprintf(rw, "delete %s\r\n", key); err != nil {
+			return err
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		line, err := rw.ReadSlice('\n')
+		if err != nil {
+			return err
+		}
+		switch string(line) {
+		case "DELETED\r\n":
+			return nil
+		case "NOT_FOUND\r\n":
+			return ErrNotFound
+		}
+		return fmt.Errorf("memcached: unexpected response %q", line)
+	})
+}
+
+func checkMemcachedKey(key string) error {
+	if len(key) == 0 || len(key) > 250 {
+		return fmt.Errorf("memcached: invalid key length %d", len(key))
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return fmt.Errorf("memcached: invalid key %q", key)
+		}
+	}
+	return nil
+}
+
+// memcachedExpiry maps a MaxAge in seconds to a memcached exptime.
+// Ages beyond 30 days must be sent as an absolute unix timestamp.
+func memcachedExpiry(maxAge int) int64 {
+	switch {
+	case maxAge < 0:
+		return -1
+	case maxAge > memcachedRelativeLimit:
+		return time.Now().Unix() + int64(maxAge)
+	}
+	return int64(maxAge)
+}
+
+type MemcachedStore struct {
+	Options         *Options // default configuration
+	SessionIDLength int
+	Client          *MemcachedClient
+	Prefix          string
+	Serializer      Serializer
+}
+
+var _ Store = &MemcachedStore{}
+
+func NewMemcachedStore(addr ...string) *MemcachedStore {
+	serverAddr := "localhost:11211"
+	if len(addr) > 0 {
+		serverAddr = addr[0]
+	}
+	return NewMemcachedStoreWithClient(NewMemcachedClient(serverAddr))
+}
+
+func NewMemcachedStoreWithClient(client *MemcachedClient) *MemcachedStore {
+	return &MemcachedStore{
+		Options: &Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		SessionIDLength: 64,
+		Prefix:          "",
+		Client:          client,
+		Serializer:      GobEncoder{},
+	}
+}
+
+// SetSerializer sets the serializer of stored sessions. Wrap a
+// SessionSerializer with AdaptSessionSerializer.
+func (s *MemcachedStore) SetSerializer(sz Serializer) {
+	s.Serializer = sz
+}
+
+func (s *MemcachedStore) Get(r *http.Request, cookieName string) (session *Session, err error) {
+	session, err = s.New(r, cookieName)
+	session.cookieName = cookieName
+	session.store = s
+	return
+}
+
+func (s *MemcachedStore) New(r *http.Request, cookieName string) (*Session, error) {
+	session := NewSession(s, cookieName)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+	var err error
+	if sid, errCookie := r.Cookie(cookieName); errCookie == nil {
+		session.ID = sid.Value
+		//get value
+		val, cas, _err := s.Client.Gets(s.Prefix + sid.Value)
+		if _err == nil {
+			_err = s.Serializer.Deserialize(val, session)
+			if _err != nil {
+				err = _err
+			}
+			session.cas = cas
+			session.IsNew = false
+		} else {
+			err = _err
+			newid := generateID(s.SessionIDLength)
+			session.ID = newid
+			session.IsNew = true
+		}
+	} else {
+		newid := generateID(s.SessionIDLength)
+		session.ID = newid
+		session.IsNew = true
+	}
+	return session, err
+}
+
+// Save adds a single session to the response.
+//
+// Sessions loaded from memcached are written back with cas, so a concurrent
+// modification makes Save fail with ErrCASConflict instead of silently
+// overwriting it.
+func (s *MemcachedStore) Save(r *http.Request, w http.ResponseWriter, session *Session) error {
+	key := s.Prefix + session.ID
+	b, err := s.Serializer.Serialize(session)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	exptime := memcachedExpiry(s.Options.MaxAge)
+	if session.cas != 0 {
+		err = s.Client.CompareAndSwap(key, b, exptime, session.cas)
+		if err == ErrNotFound {
+			// The item expired or was evicted since it was loaded.
+			err = s.Client.Add(key, b, exptime)
+		}
+	} else {
+		err = s.Client.Set(key, b, exptime)
+	}
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	// The token is spent; a second save in the same request overwrites.
+	session.cas = 0
+
+	cookie := NewCookie(session.CookieName(), session.ID, session.Options)
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+func (s *MemcachedStore) Destroy(r *http.Request, w http.ResponseWriter, session *Session) error {
+	s.Client.Delete(s.Prefix + session.ID)
+	session.cas = 0
+	opt := &Options{
+		Path:     session.Options.Path,
+		Domain:   session.Options.Domain,
+		Secure:   session.Options.Secure,
+		HttpOnly: session.Options.HttpOnly,
+		SameSite: session.Options.SameSite,
+		MaxAge:   -1,
+	}
+	http.SetCookie(w, NewCookie(session.CookieName(), "", opt))
+	return nil
+}
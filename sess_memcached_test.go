@@ -0,0 +1,205 @@
+package cartsess
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeMemcachedItem struct {
+	value   []byte
+	exptime int64
+	cas     uint64
+}
+
+// fakeMemcached is a tiny in-process server implementing the subset of the
+// memcached text protocol used by MemcachedClient.
+type fakeMemcached struct {
+	mutex   sync.Mutex
+	items   map[string]*fakeMemcachedItem
+	nextCAS uint64
+	ln      net.Listener
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeMemcached{items: make(map[string]*fakeMemcachedItem), ln: ln}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeMemcached) Addr() string { return f.ln.Addr().String() }
+
+// has reports whether an item is stored under key.
+func (f *fakeMemcached) has(key string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	_, ok := f.items[key]
+	return ok
+}
+
+func (f *fakeMemcached) serve(c net.Conn) {
+	defer c.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+		f.mutex.Lock()
+		switch fields[0] {
+		case "gets":
+			if it, ok := f.items[fields[1]]; ok {
+				fmt.Fprintf(rw, "VALUE %s 0 %d %d\r\n", fields[1], len(it.value), it.cas)
+				rw.Write(it.value)
+				rw.WriteString("\r\n")
+			}
+			rw.WriteString("END\r\n")
+		case "set", "add", "cas":
+			exptime, _ := strconv.ParseInt(fields[3], 10, 64)
+			size, _ := strconv.Atoi(fields[4])
+			buf := make([]byte, size+2)
+			f.mutex.Unlock()
+			if _, err := io.ReadFull(rw, buf); err != nil {
+				return
+			}
+			f.mutex.Lock()
+			it, exists := f.items[fields[1]]
+			switch {
+			case fields[0] == "add" && exists:
+				rw.WriteString("NOT_STORED\r\n")
+			case fields[0] == "cas" && !exists:
+				rw.WriteString("NOT_FOUND\r\n")
+			case fields[0] == "cas" && fields[5] != strconv.FormatUint(it.cas, 10):
+				rw.WriteString("EXISTS\r\n")
+			default:
+				f.nextCAS++
+				f.items[fields[1]] = &fakeMemcachedItem{value: buf[:size], exptime: exptime, cas: f.nextCAS}
+				rw.WriteString("STORED\r\n")
+			}
+		case "delete":
+			if _, ok := f.items[fields[1]]; ok {
+				delete(f.items, fields[1])
+				rw.WriteString("DELETED\r\n")
+			} else {
+				rw.WriteString("NOT_FOUND\r\n")
+			}
+		default:
+			rw.WriteString("ERROR\r\n")
+		}
+		f.mutex.Unlock()
+		rw.Flush()
+	}
+}
+
+func TestMemcachedStore_SaveAndLoad(t *testing.T) {
+	server := newFakeMemcached(t)
+	store := NewMemcachedStore(server.Addr())
+	store.Prefix = "sess:"
+
+	req1 := httptest.NewRequest("GET", "/", nil)
+	rec1 := httptest.NewRecorder()
+	session, err := store.Get(req1, "mc-session")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !session.IsNew {
+		t.Error("expected session to be new")
+	}
+	session.Values["user"] = "alice"
+	if err := session.Save(req1, rec1); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if !server.has("sess:" + session.ID) {
+		t.Fatal("expected item to be stored under prefixed key")
+	}
+
+	cookies := rec1.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("expected session cookie")
+	}
+	req2 := httptest.NewRequest("GET", "/", nil)
+	req2.AddCookie(cookies[0])
+	loaded, err := store.Get(req2, "mc-session")
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	if loaded.IsNew {
+		t.Error("expected session not to be new")
+	}
+	if loaded.Values["user"] != "alice" {
+		t.Errorf("expected user 'alice', got %v", loaded.Values["user"])
+	}
+
+	rec3 := httptest.NewRecorder()
+	if err := loaded.Destroy(req2, rec3); err != nil {
+		t.Fatalf("failed to destroy session: %v", err)
+	}
+	if server.has("sess:" + session.ID) {
+		t.Error("expected item to be deleted")
+	}
+}
+
+func TestMemcachedStore_CASConflict(t *testing.T) {
+	server := newFakeMemcached(t)
+	store := NewMemcachedStore(server.Addr())
+
+	req := httptest.NewRequest("GET", "/", nil)
+	session, _ := store.Get(req, "mc-session")
+	session.Values["n"] = 1
+	if err := session.Save(req, httptest.NewRecorder()); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Cookie", "mc-session="+session.ID)
+	first, _ := store.Get(req, "mc-session")
+	second, _ := store.Get(req, "mc-session")
+
+	first.Values["n"] = 2
+	if err := first.Save(req, httptest.NewRecorder()); err != nil {
+		t.Fatalf("failed to save first session: %v", err)
+	}
+	second.Values["n"] = 3
+	if err := second.Save(req, httptest.NewRecorder()); err != ErrCASConflict {
+		t.Errorf("expected ErrCASConflict, got %v", err)
+	}
+}
+
+func TestMemcachedExpiry(t *testing.T) {
+	if got := memcachedExpiry(3600); got != 3600 {
+		t.Errorf("expected relative 3600, got %d", got)
+	}
+	if got := memcachedExpiry(86400 * 30); got != 86400*30 {
+		t.Errorf("expected 30 days to stay relative, got %d", got)
+	}
+	if got := memcachedExpiry(86400 * 31); got < time.Now().Unix() {
+		t.Errorf("expected absolute timestamp, got %d", got)
+	}
+	if got := memcachedExpiry(-1); got != -1 {
+		t.Errorf("expected -1, got %d", got)
+	}
+}
@@ -0,0 +1,167 @@
+package cartsess
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MigrationStats counts how sessions were resolved by a MigratingStore.
+type MigrationStats struct {
+	PrimaryHits int64 // sessions found in the primary store
+	LegacyHits  int64 // sessions found in a legacy store
+	Migrated    int64 // legacy sessions saved into the primary store
+	Failed      int64 // legacy sessions whose migration failed
+	Misses      int64 // sessions found nowhere
+}
+
+// expirer is implemented by stores that can shorten the lifetime of a
+// stored session, such as RedisStore.
+type expirer interface {
+	Expire(ctx context.Context, id string, ttl time.Duration) error
+}
+
+// MigratingStore reads sessions from Primary and falls back to the Legacy
+// stores in order, so users stay logged in while moving between backends.
+//
+// A session found in a legacy store is copied into a new primary session,
+// which is saved at the end of the request even if unchanged. Once saved,
+// the legacy copy is destroyed, or expired after LegacyGrace when the
+// legacy store supports it. All stores must use the same cookie name.
+type MigratingStore struct {
+	Primary     Store
+	Legacy      []Store
+	LegacyGrace time.Duration // keep legacy copies this long after migrating (0: delete)
+
+	primaryHits atomic.Int64
+	legacyHits  atomic.Int64
+	migrated    atomic.Int64
+	failed      atomic.Int64
+	misses      atomic.Int64
+}
+
+var _ Store = &MigratingStore{}
+
+func NewMigratingStore(primary Store, legacy ...Store) *MigratingStore {
+	return &MigratingStore{
+		Primary: primary,
+		Legacy:  legacy,
+	}
+}
+
+func (s *MigratingStore) Get(r *http.Request, cookieName string) (session *Session, err error) {
+	session, err = s.New(r, cookieName)
+	session.cookieName = cookieName
+	session.store = s
+	return
+}
+
+func (s *MigratingStore) New(r *http.Request, cookieName string) (*Session, error) {
+	session, err := s.Primary.New(r, cookieName)
+	session.store = s
+	if err == nil && !session.IsNew {
+		s.primaryHits.Add(1)
+		return session, nil
+	}
+	if _, errCookie := r.Cookie(cookieName); errCookie != nil {
+		return session, err
+	}
+	for _, legacy := range s.Legacy {
+		old, errLegacy := legacy.New(r, cookieName)
+		if errLegacy != nil || old == nil || old.IsNew {
+			continue
+		}
+		old.store = legacy
+		s.legacyHits.Add(1)
+		for k, v := range old.Values {
+			session.Values[k] = v
+		}
+		session.legacy = old
+		session.needsSave = true
+		return session, nil
+	}
+	s.misses.Add(1)
+	return session, err
+}
+
+// Save adds a single session to the response. Sessions loaded from a
+// legacy store are removed from it once saved into the primary store.
+func (s *MigratingStore) Save(r *http.Request, w http.ResponseWriter, session *Session) error {
+	err := s.Primary.Save(r, w, session)
+	if session.legacy == nil {
+		return err
+	}
+	if err != nil {
+		s.failed.Add(1)
+		return err
+	}
+	s.retire(r, w, session.legacy)
+	session.legacy = nil
+	s.migrated.Add(1)
+	return nil
+}
+
+func (s *MigratingStore) Destroy(r *http.Request, w http.ResponseWriter, session *Session) error {
+	if session.legacy != nil {
+		s.retire(r, w, session.legacy)
+		session.legacy = nil
+	}
+	return s.Primary.Destroy(r, w, session)
+}
+
+// Stats returns a snapshot of the migration counters.
+func (s *MigratingStore) Stats() MigrationStats {
+	return MigrationStats{
+		PrimaryHits: s.primaryHits.Load(),
+		LegacyHits:  s.legacyHits.Load(),
+		Migrated:    s.migrated.Load(),
+		Failed:      s.failed.Load(),
+		Misses:      s.misses.Load(),
+	}
+}
+
+// retire removes the legacy copy of a migrated session. The legacy store
+// writes to w, so it can expire cookies of its own such as chunks; the
+// cookies of the primary store it overwrites are set again after it.
+func (s *MigratingStore) retire(r *http.Request, w http.ResponseWriter, legacy *Session) {
+	if s.LegacyGrace > 0 {
+		if e, ok := legacy.store.(expirer); ok {
+			ctx, cancel := Context()
+			defer cancel()
+			if err := e.Expire(ctx, legacy.ID, s.LegacyGrace); err != nil && err != ErrNotFound {
+				log.Printf(errorFormat, err)
+			}
+			return
+		}
+	}
+	primary := append([]string(nil), w.Header().Values("Set-Cookie")...)
+	if err := legacy.store.Destroy(r, w, legacy); err != nil {
+		log.Printf(errorFormat, err)
+	}
+	written := w.Header().Values("Set-Cookie")[len(primary):]
+	overwritten := make(map[string]bool, len(written))
+	for _, v := range written {
+		overwritten[setCookieName(v)] = true
+	}
+	// Move the primary store's cookies after the legacy ones.
+	header := make([]string, 0, len(primary)+len(written))
+	var reset []string
+	for _, v := range primary {
+		if overwritten[setCookieName(v)] {
+			reset = append(reset, v)
+		} else {
+			header = append(header, v)
+		}
+	}
+	header = append(header, written...)
+	w.Header()["Set-Cookie"] = append(header, reset...)
+}
+
+// setCookieName returns the name of the cookie of a Set-Cookie header.
+func setCookieName(v string) string {
+	name, _, _ := strings.Cut(v, "=")
+	return strings.TrimSpace(name)
+}
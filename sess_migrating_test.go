@@ -0,0 +1,150 @@
+package cartsess
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lastCookie returns the last cookie named name, the one browsers keep.
+func lastCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	var last *http.Cookie
+	for _, c := range cookies {
+		if c.Name == name {
+			last = c
+		}
+	}
+	return last
+}
+
+func TestMigratingStore_MigratesLegacySession(t *testing.T) {
+	legacy := NewCookieStore([]byte("legacy-hash-key-32-bytes-long!!!"))
+	primary := NewMemoryStore()
+	store := NewMigratingStore(primary, legacy)
+
+	// A session issued by the legacy store.
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	old, _ := legacy.Get(req, "sess")
+	old.Values["user"] = "alice"
+	if err := old.Save(req, rec); err != nil {
+		t.Fatalf("failed to save legacy session: %v", err)
+	}
+	legacyCookie := rec.Result().Cookies()[0]
+
+	// A read-only request migrates it.
+	handler := NewManager("sess", store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v, _ := GetByName(r.Context(), "sess").Get("user"); v != "alice" {
+			t.Errorf("expected user 'alice', got %v", v)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(legacyCookie)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// The legacy cookie is expired, then set again by the primary store.
+	cookie := lastCookie(rec.Result().Cookies(), "sess")
+	if cookie == nil || cookie.MaxAge < 0 {
+		t.Fatalf("expected the primary store's cookie last, got %v", rec.Result().Cookies())
+	}
+	if cookie.Value == legacyCookie.Value {
+		t.Fatal("expected the cookie to be reissued by the primary store")
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	session, err := primary.Get(req, "sess")
+	if err != nil || session.IsNew {
+		t.Fatalf("expected session in primary store, err %v", err)
+	}
+	if session.Values["user"] != "alice" {
+		t.Errorf("expected user 'alice', got %v", session.Values["user"])
+	}
+
+	stats := store.Stats()
+	if stats.LegacyHits != 1 || stats.Migrated != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestMigratingStore_KeyRingLegacy(t *testing.T) {
+	ring := NewKeyRing()
+	ring.Add("k1", New(GenerateRandomKey(32), nil))
+	legacy := NewCookieStoreWithKeyRing(ring)
+	primary := NewMemoryStore()
+	store := NewMigratingStore(primary, legacy)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	old, _ := legacy.Get(req, "sess")
+	old.Values["user"] = "alice"
+	old.Save(req, rec)
+
+	// A cookie of a rotated-out key still migrates during its grace period.
+	ring.Rotate("k2", New(GenerateRandomKey(32), nil), time.Hour)
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(rec.Result().Cookies()[0])
+	rec = httptest.NewRecorder()
+	manager := &SessionManager{cookieName: "sess", store: store, request: req, response: rec}
+	if v, _ := manager.Get("user"); v != "alice" {
+		t.Errorf("expected user 'alice', got %v", v)
+	}
+	if err := manager.Save(); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	cookie := lastCookie(rec.Result().Cookies(), "sess")
+	if cookie == nil || cookie.MaxAge < 0 || strings.HasPrefix(cookie.Value, "k1:") {
+		t.Fatalf("expected the primary store's cookie, got %v", rec.Result().Cookies())
+	}
+	if stats := store.Stats(); stats.Migrated != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestMigratingStore_ExpiresLegacyChunks(t *testing.T) {
+	legacy := NewCookieStore(GenerateRandomKey(32))
+	legacy.MaxChunks(4)
+	primary := NewMemoryStore()
+	store := NewMigratingStore(primary, legacy)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	old, _ := legacy.Get(req, "sess")
+	old.Values["cart"] = strings.Repeat("x", 6000)
+	if err := old.Save(req, rec); err != nil {
+		t.Fatalf("failed to save legacy session: %v", err)
+	}
+	legacyCookies := rec.Result().Cookies()
+
+	req = httptest.NewRequest("GET", "/", nil)
+	for _, c := range legacyCookies {
+		req.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	manager := &SessionManager{cookieName: "sess", store: store, request: req, response: rec}
+	manager.Get("cart")
+	if err := manager.Save(); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	cookies := rec.Result().Cookies()
+	for _, c := range legacyCookies[1:] {
+		if expired := lastCookie(cookies, c.Name); expired == nil || expired.MaxAge >= 0 {
+			t.Errorf("expected chunk %s to be expired, got %v", c.Name, expired)
+		}
+	}
+	cookie := lastCookie(cookies, "sess")
+	if cookie == nil || cookie.MaxAge < 0 {
+		t.Fatalf("expected the primary store's cookie last, got %v", cookies)
+	}
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	if session, _ := primary.Get(req, "sess"); session.IsNew {
+		t.Error("expected the session in the primary store")
+	}
+}
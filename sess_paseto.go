@@ -0,0 +1,429 @@
+package cartsess
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// Headers of the PASETO v4 tokens PasetoStore issues and accepts. Other
+// versions and purposes are rejected.
+const (
+	pasetoLocalHeader  = "v4.local."
+	pasetoPublicHeader = "v4.public."
+)
+
+var (
+	errPasetoInvalid    = errors.New("invalid paseto token")
+	errPasetoNoKey      = errors.New("paseto store has no key")
+	errPasetoUnknownKey = errors.New("unknown paseto key id")
+	errPasetoKeySize    = errors.New("invalid paseto key size")
+	errPasetoExpired    = errors.New("paseto token is expired")
+	errPasetoNotYet     = errors.New("paseto token is not valid yet")
+)
+
+var pasetoEncoding = base64.RawURLEncoding.Strict()
+
+// PasetoKey is a key PasetoStore accepts session tokens from.
+type PasetoKey struct {
+	ID string // matched against the kid of the footer; empty for tokens without one
+	// Key is a 32 byte []byte for v4.local tokens or an ed25519.PublicKey
+	// for v4.public tokens. Its type fixes the purpose it is used for.
+	Key interface{}
+}
+
+// PasetoStore implements the Store interface using PASETO v4 tokens.
+// Like JWTStore, session data is encoded in the token itself, but the
+// version and algorithms are fixed by the key: v4.local tokens are
+// encrypted with XChaCha20 and BLAKE2b-MAC, v4.public tokens are signed
+// with Ed25519.
+type PasetoStore struct {
+	LocalKey   []byte             // v4.local: 32 byte symmetric key, used if set
+	PrivateKey ed25519.PrivateKey // v4.public: signing key
+	Options    *Options           // Cookie options
+	// KeyID is written as the kid of the footer of issued tokens.
+	KeyID string
+	// VerificationKeys are accepted in addition to the store's own key,
+	// such as keys being rotated out.
+	VerificationKeys []PasetoKey
+	// Implicit is an implicit assertion bound to every token, such as the
+	// name of the service. Tokens issued with another one are rejected.
+	Implicit []byte
+	Leeway   time.Duration // clock skew tolerated when checking exp, nbf and iat
+	// Transport carries tokens between client and server. The default
+	// reads "Authorization: Bearer <token>", then the cookie, rejecting
+	// requests where they differ, and writes both the cookie and
+	// X-Paseto-Token.
+	Transport TokenTransport
+}
+
+var _ Store = &PasetoStore{}
+
+// pasetoClaims is the payload of session tokens.
+type pasetoClaims struct {
+	Data map[string]interface{} `json:"data"`
+	Iat  string                 `json:"iat"`
+	Nbf  string                 `json:"nbf"`
+	Exp  string                 `json:"exp,omitempty"`
+}
+
+// pasetoFooter is the footer of session tokens, written when a kid is set.
+type pasetoFooter struct {
+	Kid string `json:"kid"`
+}
+
+// defaultPasetoTransport reads "Authorization: Bearer <token>", then the
+// cookie, and writes both the cookie and X-Paseto-Token. Like the JWTStore
+// default, it rejects requests whose header and cookie differ.
+var defaultPasetoTransport = StrictTransports(
+	HeaderTransport{Scheme: "Bearer", ResponseHeader: "X-Paseto-Token"},
+	CookieTransport{},
+)
+
+func newPasetoStore() *PasetoStore {
+	return &PasetoStore{
+		Options: &Options{
+			Path:     "/",
+			MaxAge:   86400 * 7, // 7 days
+			HttpOnly: true,
+		},
+	}
+}
+
+// NewPasetoLocalStore creates a PasetoStore issuing v4.local tokens, whose
+// values clients can not read. key must be 32 bytes.
+func NewPasetoLocalStore(key []byte) *PasetoStore {
+	s := newPasetoStore()
+	s.LocalKey = key
+	return s
+}
+
+// NewPasetoPublicStore creates a PasetoStore issuing v4.public tokens,
+// which services holding only the public key can verify.
+func NewPasetoPublicStore(key ed25519.PrivateKey) *PasetoStore {
+	s := newPasetoStore()
+	s.PrivateKey = key
+	return s
+}
+
+// NewPasetoVerifier creates a PasetoStore that loads sessions from tokens
+// of any of keys, but cannot issue tokens itself.
+func NewPasetoVerifier(keys ...PasetoKey) *PasetoStore {
+	s := newPasetoStore()
+	s.VerificationKeys = keys
+	return s
+}
+
+// Get retrieves a session from the request.
+func (s *PasetoStore) Get(r *http.Request, name string) (*Session, error) {
+	session, err := s.New(r, name)
+	session.cookieName = name
+	session.store = s
+	return session, err
+}
+
+// New creates a new session and attempts to load existing data from the
+// token of the request.
+func (s *PasetoStore) New(r *http.Request, name string) (*Session, error) {
+	session := NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	token, err := s.transport().Token(r, name)
+	if err != nil || token == "" {
+		return session, err
+	}
+	payload, err := s.decode(token)
+	if err != nil {
+		return session, err
+	}
+	var claims pasetoClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return session, errPasetoInvalid
+	}
+	if err := s.validate(&claims, time.Now()); err != nil {
+		return session, err
+	}
+	if claims.Data != nil {
+		session.Values = claims.Data
+		session.IsNew = false
+	}
+	return session, nil
+}
+
+// Save encodes the session as a PASETO token and hands it to the client
+// with Transport.
+func (s *PasetoStore) Save(r *http.Request, w http.ResponseWriter, session *Session) error {
+	now := time.Now().UTC()
+	claims := pasetoClaims{
+		Data: session.Values,
+		Iat:  now.Format(time.RFC3339),
+		Nbf:  now.Format(time.RFC3339),
+	}
+	if session.Options.MaxAge > 0 {
+		claims.Exp = now.Add(time.Duration(session.Options.MaxAge) * time.Second).Format(time.RFC3339)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+	token, err := s.encode(payload)
+	if err != nil {
+		return err
+	}
+	s.transport().SetToken(w, session.CookieName(), token, session.Options)
+	return nil
+}
+
+// Destroy removes the session by setting an expired cookie, or whatever
+// Transport does to clear tokens.
+func (s *PasetoStore) Destroy(r *http.Request, w http.ResponseWriter, session *Session) error {
+	opt := &Options{
+		Path:     session.Options.Path,
+		Domain:   session.Options.Domain,
+		Secure:   session.Options.Secure,
+		HttpOnly: session.Options.HttpOnly,
+		MaxAge:   -1,
+	}
+	s.transport().SetToken(w, session.CookieName(), "", opt)
+	return nil
+}
+
+// MaxAge sets the maximum age for the store's options.
+func (s *PasetoStore) MaxAge(age int) {
+	s.Options.MaxAge = age
+}
+
+// transport returns the transport of tokens.
+func (s *PasetoStore) transport() TokenTransport {
+	if s.Transport != nil {
+		return s.Transport
+	}
+	return defaultPasetoTransport
+}
+
+// validate checks the times of claims against now. exp is required when
+// the store sets one.
+func (s *PasetoStore) validate(claims *pasetoClaims, now time.Time) error {
+	parse := func(v string) (time.Time, error) {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return t, errPasetoInvalid
+		}
+		return t, nil
+	}
+	if claims.Exp != "" || s.Options.MaxAge > 0 {
+		exp, err := parse(claims.Exp)
+		if err != nil {
+			return err
+		}
+		if !now.Before(exp.Add(s.Leeway)) {
+			return errPasetoExpired
+		}
+	}
+	for _, v := range []string{claims.Iat, claims.Nbf} {
+		if v == "" {
+			continue
+		}
+		t, err := parse(v)
+		if err != nil {
+			return err
+		}
+		if now.Add(s.Leeway).Before(t) {
+			return errPasetoNotYet
+		}
+	}
+	return nil
+}
+
+// encode seals payload into a token with the store's own key.
+func (s *PasetoStore) encode(payload []byte) (string, error) {
+	var footer []byte
+	if s.KeyID != "" {
+		footer, _ = json.Marshal(pasetoFooter{Kid: s.KeyID})
+	}
+	var token string
+	switch {
+	case len(s.LocalKey) > 0:
+		if len(s.LocalKey) != 32 {
+			return "", errPasetoKeySize
+		}
+		n := make([]byte, 32)
+		if _, err := rand.Read(n); err != nil {
+			return "", err
+		}
+		token = pasetoEncrypt(s.LocalKey, n, payload, footer, s.Implicit)
+	case len(s.PrivateKey) > 0:
+		if len(s.PrivateKey) != ed25519.PrivateKeySize {
+			return "", errPasetoKeySize
+		}
+		m2 := pae([]byte(pasetoPublicHeader), payload, footer, s.Implicit)
+		sig := ed25519.Sign(s.PrivateKey, m2)
+		token = pasetoPublicHeader + pasetoEncoding.EncodeToString(append(payload, sig...))
+	default:
+		return "", errPasetoNoKey
+	}
+	if len(footer) > 0 {
+		token += "." + pasetoEncoding.EncodeToString(footer)
+	}
+	return token, nil
+}
+
+// decode verifies a token and returns its payload.
+func (s *PasetoStore) decode(token string) ([]byte, error) {
+	var header string
+	switch {
+	case strings.HasPrefix(token, pasetoLocalHeader):
+		header = pasetoLocalHeader
+	case strings.HasPrefix(token, pasetoPublicHeader):
+		header = pasetoPublicHeader
+	default:
+		return nil, errPasetoInvalid
+	}
+	body, encodedFooter, _ := strings.Cut(token[len(header):], ".")
+	if strings.Contains(encodedFooter, ".") {
+		return nil, errPasetoInvalid
+	}
+	m, err := pasetoEncoding.DecodeString(body)
+	if err != nil {
+		return nil, errPasetoInvalid
+	}
+	footer, err := pasetoEncoding.DecodeString(encodedFooter)
+	if err != nil {
+		return nil, errPasetoInvalid
+	}
+	var kid string
+	if len(footer) > 0 {
+		var f pasetoFooter
+		if err := json.Unmarshal(footer, &f); err != nil {
+			return nil, errPasetoInvalid
+		}
+		kid = f.Kid
+	}
+
+	found := false
+	for _, key := range s.candidates(kid) {
+		switch k := key.Key.(type) {
+		case []byte:
+			if header != pasetoLocalHeader || len(k) != 32 {
+				continue
+			}
+			found = true
+			if payload, err := pasetoDecrypt(k, m, footer, s.Implicit); err == nil {
+				return payload, nil
+			}
+		case ed25519.PublicKey:
+			if header != pasetoPublicHeader || len(k) != ed25519.PublicKeySize || len(m) < ed25519.SignatureSize {
+				continue
+			}
+			found = true
+			payload, sig := m[:len(m)-ed25519.SignatureSize], m[len(m)-ed25519.SignatureSize:]
+			if ed25519.Verify(k, pae([]byte(header), payload, footer, s.Implicit), sig) {
+				return payload, nil
+			}
+		}
+	}
+	if !found {
+		return nil, errPasetoUnknownKey
+	}
+	return nil, errPasetoInvalid
+}
+
+// candidates returns the keys a token with kid in its footer may be
+// verified with: the store's own key, also for tokens without kid, and the
+// VerificationKeys with ID kid.
+func (s *PasetoStore) candidates(kid string) []PasetoKey {
+	var keys []PasetoKey
+	if kid == "" || kid == s.KeyID {
+		switch {
+		case len(s.LocalKey) > 0:
+			keys = append(keys, PasetoKey{ID: s.KeyID, Key: s.LocalKey})
+		case len(s.PrivateKey) == ed25519.PrivateKeySize:
+			keys = append(keys, PasetoKey{ID: s.KeyID, Key: s.PrivateKey.Public()})
+		}
+	}
+	for _, key := range s.VerificationKeys {
+		if key.ID == kid {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// pasetoEncrypt returns the v4.local token of payload with nonce n.
+func pasetoEncrypt(key, n, payload, footer, implicit []byte) string {
+	ek, n2, ak := pasetoLocalKeys(key, n)
+	c := xchacha20(ek, n2, payload)
+	t := keyedHash(32, ak, pae([]byte(pasetoLocalHeader), n, c, footer, implicit))
+	m := append(append(append([]byte{}, n...), c...), t...)
+	return pasetoLocalHeader + pasetoEncoding.EncodeToString(m)
+}
+
+// pasetoDecrypt opens the decoded body m of a v4.local token.
+func pasetoDecrypt(key, m, footer, implicit []byte) ([]byte, error) {
+	if len(m) < 64 {
+		return nil, errPasetoInvalid
+	}
+	n, c, t := m[:32], m[32:len(m)-32], m[len(m)-32:]
+	ek, n2, ak := pasetoLocalKeys(key, n)
+	t2 := keyedHash(32, ak, pae([]byte(pasetoLocalHeader), n, c, footer, implicit))
+	if subtle.ConstantTimeCompare(t, t2) != 1 {
+		return nil, errPasetoInvalid
+	}
+	return xchacha20(ek, n2, c), nil
+}
+
+// pasetoLocalKeys splits key into the encryption key, nonce and
+// authentication key of the v4.local token with nonce n.
+func pasetoLocalKeys(key, n []byte) (ek, n2, ak []byte) {
+	tmp := keyedHash(56, key, []byte("paseto-encryption-key"), n)
+	ak = keyedHash(32, key, []byte("paseto-auth-key-for-aead"), n)
+	return tmp[:32], tmp[32:], ak
+}
+
+// keyedHash returns the size byte BLAKE2b-MAC of msg. Sizes and key
+// lengths are fixed by the callers, so errors cannot happen.
+func keyedHash(size int, key []byte, msg ...[]byte) []byte {
+	h, err := blake2b.New(size, key)
+	if err != nil {
+		panic(err)
+	}
+	for _, m := range msg {
+		h.Write(m)
+	}
+	return h.Sum(nil)
+}
+
+// xchacha20 XORs src with the XChaCha20 keystream of a 32 byte key and a
+// 24 byte nonce.
+func xchacha20(key, nonce, src []byte) []byte {
+	c, err := chacha20.NewUnauthenticatedCipher(key, nonce)
+	if err != nil {
+		panic(err)
+	}
+	dst := make([]byte, len(src))
+	c.XORKeyStream(dst, src)
+	return dst
+}
+
+// pae is the pre-authentication encoding of PASETO.
+func pae(pieces ...[]byte) []byte {
+	out := make([]byte, 8, 8+8*len(pieces))
+	binary.LittleEndian.PutUint64(out, uint64(len(pieces)))
+	for _, p := range pieces {
+		out = binary.LittleEndian.AppendUint64(out, uint64(len(p)))
+		out = append(out, p...)
+	}
+	return out
+}
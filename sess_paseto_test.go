@@ -0,0 +1,146 @@
+package cartsess
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issuePaseto saves a session with store and returns its token.
+func issuePaseto(t *testing.T, store *PasetoStore) string {
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "paseto-session")
+	session.Values["user"] = "gopher"
+	if err := session.Save(req, rec); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	return rec.Header().Get("X-Paseto-Token")
+}
+
+// loadPaseto loads the session of token with store.
+func loadPaseto(store *PasetoStore, token string) (*Session, error) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "paseto-session", Value: token})
+	return store.Get(req, "paseto-session")
+}
+
+func TestPasetoVectors(t *testing.T) {
+	// PASETO test vector 4-E-1.
+	key, _ := hex.DecodeString("707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f")
+	token := pasetoEncrypt(key, make([]byte, 32), []byte(`{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`), nil, nil)
+	want := "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQg"
+	if token != want {
+		t.Errorf("4-E-1: got %s", token)
+	}
+
+	// PASETO test vector 4-S-1.
+	sk, _ := hex.DecodeString("b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a37741eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2")
+	token, err := NewPasetoPublicStore(sk).encode([]byte(`{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`))
+	want = "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA"
+	if err != nil || token != want {
+		t.Errorf("4-S-1: got %s, %v", token, err)
+	}
+}
+
+func TestPasetoStore(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	local := NewPasetoLocalStore(bytes.Repeat([]byte{7}, 32))
+	public := NewPasetoPublicStore(priv)
+
+	for name, store := range map[string]*PasetoStore{"v4.local": local, "v4.public": public} {
+		token := issuePaseto(t, store)
+		if !strings.HasPrefix(token, name+".") {
+			t.Fatalf("%s: unexpected token %q", name, token)
+		}
+		session, err := loadPaseto(store, token)
+		if err != nil {
+			t.Fatalf("%s: failed to load: %v", name, err)
+		}
+		if session.IsNew || session.Values["user"] != "gopher" {
+			t.Errorf("%s: expected values to round-trip, got %v", name, session.Values)
+		}
+
+		// A changed byte anywhere in the body is rejected.
+		body := []byte(token)
+		i := len(name) + 1 + len(body[len(name)+1:])/2
+		body[i] ^= 1
+		if _, err := loadPaseto(store, string(body)); err == nil {
+			t.Errorf("%s: expected a tampered token to be rejected", name)
+		}
+	}
+
+	// v4.local values are not readable.
+	if strings.Contains(issuePaseto(t, local), "Z29waGVy") {
+		t.Error("expected v4.local tokens to be encrypted")
+	}
+
+	// The purpose is fixed by the key: tokens of the other purpose fail.
+	if _, err := loadPaseto(local, issuePaseto(t, public)); err == nil {
+		t.Error("expected v4.public tokens to be rejected by a v4.local store")
+	}
+
+	// Verifiers hold the public key only.
+	verifier := NewPasetoVerifier(PasetoKey{Key: pub})
+	if _, err := loadPaseto(verifier, issuePaseto(t, public)); err != nil {
+		t.Errorf("expected the verifier to load the token: %v", err)
+	}
+	session, _ := verifier.New(httptest.NewRequest("GET", "/", nil), "paseto-session")
+	if err := session.Save(httptest.NewRequest("GET", "/", nil), httptest.NewRecorder()); !errors.Is(err, errPasetoNoKey) {
+		t.Errorf("expected a verifier not to issue tokens, got %v", err)
+	}
+	// A header and a cookie with different tokens are ambiguous.
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+issuePaseto(t, local))
+	req.AddCookie(&http.Cookie{Name: "paseto-session", Value: issuePaseto(t, local)})
+	if _, err := local.Get(req, "paseto-session"); !errors.Is(err, ErrAmbiguousToken) {
+		t.Errorf("expected ErrAmbiguousToken, got %v", err)
+	}
+}
+
+func TestPasetoStore_Claims(t *testing.T) {
+	store := NewPasetoLocalStore(bytes.Repeat([]byte{7}, 32))
+
+	if err := store.validate(&pasetoClaims{Exp: "2022-01-01T00:00:00+00:00"}, time.Now()); !errors.Is(err, errPasetoExpired) {
+		t.Errorf("expected an expired token to be rejected, got %v", err)
+	}
+
+	// exp is required once the store sets one.
+	store.MaxAge(-1)
+	token := issuePaseto(t, store)
+	store.MaxAge(3600)
+	if _, err := loadPaseto(store, token); !errors.Is(err, errPasetoInvalid) {
+		t.Errorf("expected a token without exp to be rejected, got %v", err)
+	}
+
+	// The implicit assertion must match.
+	store.Implicit = []byte("shop")
+	token = issuePaseto(t, store)
+	store.Implicit = []byte("admin")
+	if _, err := loadPaseto(store, token); err == nil {
+		t.Error("expected a token of another implicit assertion to be rejected")
+	}
+	store.Implicit = []byte("shop")
+
+	// Rotation: old tokens load by the kid in their footer.
+	store.KeyID = "k1"
+	old := issuePaseto(t, store)
+	oldKey := store.LocalKey
+	store.LocalKey = bytes.Repeat([]byte{8}, 32)
+	store.KeyID = "k2"
+	store.VerificationKeys = []PasetoKey{{ID: "k1", Key: oldKey}}
+	if _, err := loadPaseto(store, old); err != nil {
+		t.Errorf("expected tokens of the old key to load: %v", err)
+	}
+	store.VerificationKeys = nil
+	if _, err := loadPaseto(store, old); !errors.Is(err, errPasetoUnknownKey) {
+		t.Errorf("expected tokens of a removed key to be rejected, got %v", err)
+	}
+}
@@ -1,12 +1,10 @@
 package cartsess
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
-	"encoding/json"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -17,7 +15,16 @@ type RedisStore struct {
 	SessionIDLength int
 	Client          redis.UniversalClient
 	Prefix          string
-	Serializer      SessionSerializer
+	Serializer      Serializer
+	Layout          RedisLayout           // how sessions are laid out in redis (default: RedisLayoutString)
+	UserIDKey       string                // session value holding the user ID, for metadata and UserIndex
+	UserIndex       bool                  // maintain a per-user index of session IDs, see ListUserSessions
+	HashTag         bool                  // wrap IDs in keys in a cluster hash tag, see sessionKey
+	ReadClient      redis.UniversalClient // optional client for loading sessions, e.g. replicas
+	Audit           *AuditLog             // optional audit stream of session lifecycle events
+
+	listenerMutex sync.RWMutex
+	listeners     []func(SessionEvent)
 }
 
 var _ Store = &RedisStore{}
@@ -27,51 +34,6 @@ func Context() (context.Context, context.CancelFunc) {
 	return ctx, cancel
 }
 
-type SessionSerializer interface {
-	Deserialize(d []byte, session *Session) error
-	Serialize(session *Session) ([]byte, error)
-}
-
-type JSONSerializer struct{}
-
-func (s JSONSerializer) Serialize(session *Session) ([]byte, error) {
-	m := make(map[string]interface{}, len(session.Values))
-	for k, v := range session.Values {
-		m[k] = v
-	}
-	return json.Marshal(m)
-}
-
-func (s JSONSerializer) Deserialize(d []byte, session *Session) error {
-	m := make(map[string]interface{})
-	err := json.Unmarshal(d, &m)
-	if err != nil {
-		log.Printf("redistore.JSONSerializer.deserialize() Error: %v", err)
-		return err
-	}
-	for k, v := range m {
-		session.Values[k] = v
-	}
-	return nil
-}
-
-type GobSerializer struct{}
-
-func (s GobSerializer) Serialize(session *Session) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	enc := gob.NewEncoder(buf)
-	err := enc.Encode(session.Values)
-	if err == nil {
-		return buf.Bytes(), nil
-	}
-	return nil, err
-}
-
-func (s GobSerializer) Deserialize(d []byte, session *Session) error {
-	dec := gob.NewDecoder(bytes.NewBuffer(d))
-	return dec.Decode(&session.Values)
-}
-
 func NewRedisStore(opts ...*redis.Options) *RedisStore {
 	var redisOpt *redis.Options
 	if len(opts) > 0 {
@@ -96,12 +58,14 @@ func NewRedisStoreWithClient(client redis.UniversalClient) *RedisStore {
 		SessionIDLength: 64,
 		Prefix:          "",
 		Client:          client,
-		Serializer:      GobSerializer{},
+		Serializer:      GobEncoder{},
 	}
 }
 
-func (s *RedisStore) SetSerializer(sessionSerializer SessionSerializer) {
-	s.Serializer = sessionSerializer
+// SetSerializer sets the serializer of stored sessions. Wrap a
+// SessionSerializer with AdaptSessionSerializer.
+func (s *RedisStore) SetSerializer(sz Serializer) {
+	s.Serializer = sz
 }
 
 func (s *RedisStore) Get(r *http.Request, cookieName string) (session *Session, err error) {
@@ -122,13 +86,29 @@ func (s *RedisStore) New(r *http.Request, cookieName string) (*Session, error) {
 		//get value
 		ctx, cancel := Context()
 		defer cancel()
-		val, _err := s.Client.Get(ctx, s.Prefix+sid.Value).Result()
-		if _err == nil {
-			_err = s.Serializer.Deserialize([]byte(val), session)
-			if _err != nil {
-				err = _err
+		var _err error
+		if s.Layout == RedisLayoutHash {
+			var fields map[string]string
+			fields, _err = s.reader().HGetAll(ctx, s.sessionKey(sid.Value)).Result()
+			if _err == nil && len(fields) == 0 {
+				_err = redis.Nil
+			}
+			if _err == nil {
+				err = s.decodeHash(fields, session)
+			}
+		} else {
+			var val string
+			val, _err = s.reader().Get(ctx, s.sessionKey(sid.Value)).Result()
+			if _err == nil {
+				err = s.Serializer.Deserialize([]byte(val), session)
 			}
+		}
+		if _err == nil {
 			session.IsNew = false
+			session.indexedUserID = s.userID(session)
+			if s.Audit != nil && s.Audit.IncludeKeys {
+				session.loadedValues = copyValues(session.Values)
+			}
 		} else {
 			if _err == redis.Nil {
 				err = ErrNotFound
@@ -149,30 +129,89 @@ func (s *RedisStore) New(r *http.Request, cookieName string) (*Session, error) {
 
 // Save adds a single session to the response.
 func (s *RedisStore) Save(r *http.Request, w http.ResponseWriter, session *Session) error {
-	sid := session.ID
-	b, err := s.Serializer.Serialize(session)
+	var write func(ctx context.Context, pipe redis.Pipeliner)
+	var saved func()
+	var err error
+	if s.Layout == RedisLayoutHash {
+		write, saved, err = s.hashWriter(r, session)
+	} else {
+		write, err = s.stringWriter(session)
+	}
 	if err != nil {
 		log.Println(err)
 		return err
 	}
+	auditType := AuditUpdate
+	if session.regenerated {
+		auditType = AuditRegenerate
+	} else if session.IsNew {
+		auditType = AuditCreate
+	}
 	ctx, cancel := Context()
 	defer cancel()
-	err = s.Client.Set(ctx, s.Prefix+sid, string(b), time.Duration(s.Options.MaxAge)*time.Second).Err()
+	_, err = s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		write(ctx, pipe)
+		if s.UserIndex {
+			s.indexUser(ctx, pipe, session)
+		}
+		if s.Audit != nil {
+			s.auditSave(ctx, pipe, session, auditType)
+		}
+		return nil
+	})
 	if err != nil {
 		log.Println(err)
 		return err
 	}
+	if saved != nil {
+		saved()
+	}
+	if s.UserIndex {
+		session.indexedUserID = s.userID(session)
+	}
+	if s.Audit != nil && s.Audit.IncludeKeys {
+		session.loadedValues = copyValues(session.Values)
+	}
+	session.IsNew = false
+	session.regenerated = false
 
 	cookie := NewCookie(session.CookieName(), session.ID, session.Options)
 	http.SetCookie(w, cookie)
 	return nil
 }
 
+func (s *RedisStore) stringWriter(session *Session) (func(ctx context.Context, pipe redis.Pipeliner), error) {
+	b, err := s.Serializer.Serialize(session)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.Set(ctx, s.sessionKey(session.ID), string(b), time.Duration(s.Options.MaxAge)*time.Second)
+	}, nil
+}
+
 func (s *RedisStore) Destroy(r *http.Request, w http.ResponseWriter, session *Session) error {
 	sid := session.ID
 	ctx, cancel := Context()
 	defer cancel()
-	s.Client.Del(ctx, s.Prefix+sid)
+	_, err := s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.sessionKey(sid))
+		if s.Audit != nil {
+			s.Audit.add(ctx, pipe, AuditEvent{
+				Type:      AuditDestroy,
+				SessionID: session.ID,
+				UserID:    s.userID(session),
+				Time:      time.Now(),
+			})
+		}
+		if s.UserIndex {
+			s.unindexUser(ctx, pipe, session)
+		}
+		return nil
+	})
+	if err == nil {
+		session.indexedUserID = ""
+	}
 	opt := &Options{
 		Path:     session.Options.Path,
 		Domain:   session.Options.Domain,
@@ -0,0 +1,272 @@
+package cartsess
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AuditEventType is the kind of session lifecycle event recorded by AuditLog.
+type AuditEventType string
+
+const (
+	AuditCreate     AuditEventType = "create"
+	AuditRegenerate AuditEventType = "regenerate"
+	AuditUpdate     AuditEventType = "update"
+	AuditDestroy    AuditEventType = "destroy"
+	AuditExpire     AuditEventType = "expire"
+)
+
+// Redacted replaces values hidden by a RedactFunc.
+const Redacted = "[REDACTED]"
+
+// RedactFunc renders the value of a changed key for the audit log. Values
+// are personal data more often than not, so it should hide anything that
+// is not explicitly safe to record.
+type RedactFunc func(key string, value interface{}) string
+
+// RedactAll records changed keys without their values.
+func RedactAll(key string, value interface{}) string {
+	return Redacted
+}
+
+// RedactExcept records the values of the given keys and hides all others.
+func RedactExcept(keys ...string) RedactFunc {
+	allowed := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		allowed[k] = true
+	}
+	return func(key string, value interface{}) string {
+		if allowed[key] {
+			return fmt.Sprint(value)
+		}
+		return Redacted
+	}
+}
+
+// AuditEvent is one entry of the audit stream.
+type AuditEvent struct {
+	StreamID  string // entry ID assigned by redis
+	Type      AuditEventType
+	SessionID string
+	UserID    string
+	Time      time.Time
+	// Keys maps the keys changed by the event to their rendered values,
+	// or to "" for keys that were removed. Only set with IncludeKeys.
+	Keys map[string]string
+}
+
+// AuditLog appends session lifecycle events to a capped redis stream.
+type AuditLog struct {
+	Client      redis.UniversalClient
+	Stream      string
+	MaxLen      int64 // approximate cap of the stream length (0: uncapped)
+	IncludeKeys bool  // record changed keys and emit AuditUpdate events
+	Redact      RedactFunc
+	// For testing purposes, how long Follow waits for new entries.
+	block time.Duration
+}
+
+func NewAuditLog(client redis.UniversalClient, stream string) *AuditLog {
+	return &AuditLog{
+		Client: client,
+		Stream: stream,
+		MaxLen: 100000,
+		Redact: RedactAll,
+	}
+}
+
+func (a *AuditLog) add(ctx context.Context, pipe redis.Cmdable, event AuditEvent) {
+	values := []interface{}{
+		"type", string(event.Type),
+		"sid", event.SessionID,
+		"uid", event.UserID,
+		"ts", event.Time.UnixMilli(),
+	}
+	if len(event.Keys) > 0 {
+		b, _ := json.Marshal(event.Keys)
+		values = append(values, "keys", string(b))
+	}
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: a.Stream,
+		MaxLen: a.MaxLen,
+		Approx: true,
+		Values: values,
+	})
+}
+
+// changedKeys renders the keys whose values differ between old and new.
+func (a *AuditLog) changedKeys(old, new map[string]interface{}) map[string]string {
+	redact := a.Redact
+	if redact == nil {
+		redact = RedactAll
+	}
+	keys := make(map[string]string)
+	for k, v := range new {
+		if ov, ok := old[k]; !ok || !reflect.DeepEqual(ov, v) {
+			keys[k] = redact(k, v)
+		}
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			keys[k] = ""
+		}
+	}
+	return keys
+}
+
+// Range returns up to count events with stream IDs between start and end,
+// which may be "-" and "+" for the beginning and end of the stream.
+func (a *AuditLog) Range(ctx context.Context, start, end string, count int64) ([]AuditEvent, error) {
+	msgs, err := a.Client.XRangeN(ctx, a.Stream, start, end, count).Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]AuditEvent, len(msgs))
+	for i, msg := range msgs {
+		events[i] = parseAuditEvent(msg)
+	}
+	return events, nil
+}
+
+// Follow calls fn for every event appended after the stream ID last ("$"
+// for only new events) until ctx is done.
+func (a *AuditLog) Follow(ctx context.Context, last string, fn func(AuditEvent)) error {
+	if last == "$" {
+		// "$" means new to each XREAD, so entries appended between two
+		// reads would be missed: start from the current last entry.
+		msgs, err := a.Client.XRevRangeN(ctx, a.Stream, "+", "-", 1).Result()
+		if err != nil {
+			return err
+		}
+		last = "0-0"
+		if len(msgs) > 0 {
+			last = msgs[0].ID
+		}
+	}
+	block := a.block
+	if block == 0 {
+		block = 5 * time.Second
+	}
+	for {
+		streams, err := a.Client.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{a.Stream, last},
+			Count:   100,
+			Block:   block,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				fn(parseAuditEvent(msg))
+				last = msg.ID
+			}
+		}
+	}
+}
+
+func parseAuditEvent(msg redis.XMessage) AuditEvent {
+	str := func(k string) string {
+		s, _ := msg.Values[k].(string)
+		return s
+	}
+	event := AuditEvent{
+		StreamID:  msg.ID,
+		Type:      AuditEventType(str("type")),
+		SessionID: str("sid"),
+		UserID:    str("uid"),
+	}
+	if ms, err := strconv.ParseInt(str("ts"), 10, 64); err == nil {
+		event.Time = time.UnixMilli(ms)
+	}
+	if keys := str("keys"); keys != "" {
+		json.Unmarshal([]byte(keys), &event.Keys)
+	}
+	return event
+}
+
+// auditSave queues the audit entry for a session being saved.
+func (s *RedisStore) auditSave(ctx context.Context, pipe redis.Pipeliner, session *Session, typ AuditEventType) {
+	a := s.Audit
+	event := AuditEvent{
+		Type:      typ,
+		SessionID: session.ID,
+		UserID:    s.userID(session),
+		Time:      time.Now(),
+	}
+	if a.IncludeKeys {
+		event.Keys = a.changedKeys(session.loadedValues, session.Values)
+	}
+	if typ == AuditUpdate && len(event.Keys) == 0 {
+		return
+	}
+	a.add(ctx, pipe, event)
+}
+
+// expireMarkerTTL is how long auditExpire remembers an expired session.
+// Every watcher receives the notification at about the same time.
+const expireMarkerTTL = time.Minute
+
+// auditExpire records the expiry of a session once, however many
+// WatchEvents instances received the notification: the first to set a
+// marker key next to the stream writes the event.
+func (s *RedisStore) auditExpire(sessionID string) {
+	ctx, cancel := Context()
+	defer cancel()
+	first, err := s.Client.SetNX(ctx, s.Audit.Stream+":expired:"+sessionID, 1, expireMarkerTTL).Result()
+	if err != nil {
+		log.Printf(errorFormat, err)
+		return
+	}
+	if first {
+		s.audit(AuditExpire, sessionID, "")
+	}
+}
+
+// audit records an event that is not part of a save.
+func (s *RedisStore) audit(typ AuditEventType, sessionID, userID string) {
+	ctx, cancel := Context()
+	defer cancel()
+	s.Audit.add(ctx, s.Client, AuditEvent{
+		Type:      typ,
+		SessionID: sessionID,
+		UserID:    userID,
+		Time:      time.Now(),
+	})
+}
+
+// Regenerate moves the session to a new ID, typically after login to
+// prevent session fixation. The old key is deleted and the session is
+// saved under the new ID.
+func (s *RedisStore) Regenerate(r *http.Request, w http.ResponseWriter, session *Session) error {
+	oldID, oldUserID := session.ID, session.indexedUserID
+	session.ID = generateID(s.SessionIDLength)
+	session.regenerated = true
+	if err := s.Save(r, w, session); err != nil {
+		return err
+	}
+	ctx, cancel := Context()
+	defer cancel()
+	_, err := s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.sessionKey(oldID))
+		if s.UserIndex && oldUserID != "" {
+			pipe.ZRem(ctx, s.userIndexKey(oldUserID), oldID)
+		}
+		return nil
+	})
+	return err
+}
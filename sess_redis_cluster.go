@@ -0,0 +1,68 @@
+package cartsess
+
+import (
+	"github.com/redis/go-redis/v9"
+)
+
+// NewRedisFailoverStore creates a RedisStore backed by a Sentinel-managed
+// master. With readFromReplica, sessions are loaded from a replica chosen
+// by Sentinel and written to the master.
+func NewRedisFailoverStore(opts *redis.FailoverOptions, readFromReplica bool) *RedisStore {
+	s := NewRedisStoreWithClient(redis.NewFailoverClient(opts))
+	if readFromReplica {
+		replicaOpts := *opts
+		replicaOpts.ReplicaOnly = true
+		s.ReadClient = redis.NewFailoverClient(&replicaOpts)
+	}
+	return s
+}
+
+// NewRedisClusterStore creates a RedisStore backed by a Redis Cluster.
+// Keys are wrapped in hash tags, see RedisStore.HashTag. Set opts.ReadOnly
+// (with RouteByLatency or RouteRandomly) to load sessions from replicas.
+func NewRedisClusterStore(opts *redis.ClusterOptions) *RedisStore {
+	s := NewRedisStoreWithClient(redis.NewClusterClient(opts))
+	s.HashTag = true
+	return s
+}
+
+// NewRedisRingStore creates a RedisStore sharded client-side over a Ring.
+// Keys are wrapped in hash tags, which Ring also uses to pick the shard.
+func NewRedisRingStore(opts *redis.RingOptions) *RedisStore {
+	s := NewRedisStoreWithClient(redis.NewRing(opts))
+	s.HashTag = true
+	return s
+}
+
+// NewRedisUniversalStore creates a RedisStore whose topology is picked by
+// redis.NewUniversalClient: Sentinel when opts.MasterName is set, Cluster
+// for several addresses, a single node otherwise.
+func NewRedisUniversalStore(opts *redis.UniversalOptions) *RedisStore {
+	s := NewRedisStoreWithClient(redis.NewUniversalClient(opts))
+	if opts.MasterName == "" && len(opts.Addrs) > 1 {
+		s.HashTag = true
+	}
+	return s
+}
+
+// sessionKey returns the redis key of the session with the given ID.
+//
+// With HashTag the ID is wrapped in braces, Prefix{ID}, so every key
+// derived from one session hashes to the same cluster slot and can be used
+// together in transactions and Lua scripts. Per-user indexes are tagged
+// with the user ID instead; updates spanning a session and its user's
+// index are then split into one transaction per slot.
+func (s *RedisStore) sessionKey(id string) string {
+	if s.HashTag {
+		return s.Prefix + "{" + id + "}"
+	}
+	return s.Prefix + id
+}
+
+// reader returns the client used to load sessions.
+func (s *RedisStore) reader() redis.Cmdable {
+	if s.ReadClient != nil {
+		return s.ReadClient
+	}
+	return s.Client
+}
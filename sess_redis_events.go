@@ -0,0 +1,129 @@
+package cartsess
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// SessionEventType identifies what happened to a session.
+type SessionEventType int
+
+const (
+	// SessionExpired is emitted when redis expires a session key.
+	SessionExpired SessionEventType = iota + 1
+	// SessionDeleted is emitted when a session key is deleted, by Destroy,
+	// RevokeUserSessions or any other client.
+	SessionDeleted
+)
+
+func (t SessionEventType) String() string {
+	switch t {
+	case SessionExpired:
+		return "expired"
+	case SessionDeleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// SessionEvent describes a change to a stored session.
+type SessionEvent struct {
+	Type SessionEventType
+	ID   string // session ID, without Prefix
+	Time time.Time
+}
+
+var errWatchNoPrefix = errors.New("WatchEvents requires a Prefix")
+
+// keyspaceEventPatterns are the keyevent channels WatchEvents subscribes to.
+var keyspaceEventPatterns = []string{
+	"__keyevent@*__:expired",
+	"__keyevent@*__:del",
+}
+
+// OnEvent registers fn to be called for every session event received by
+// WatchEvents. Callbacks run on the WatchEvents goroutine, in order.
+func (s *RedisStore) OnEvent(fn func(SessionEvent)) {
+	s.listenerMutex.Lock()
+	defer s.listenerMutex.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// EnableKeyspaceEvents turns on the redis keyspace notifications needed by
+// WatchEvents. Managed redis services often forbid CONFIG; configure
+// notify-keyspace-events to include "Egx" there instead.
+func (s *RedisStore) EnableKeyspaceEvents(ctx context.Context) error {
+	return s.Client.ConfigSet(ctx, "notify-keyspace-events", "Egx").Err()
+}
+
+// WatchEvents subscribes to the expired and del keyevent notifications and
+// dispatches those for session keys under Prefix to the callbacks
+// registered with OnEvent. It blocks until ctx is done or the subscription
+// fails, so run it in its own goroutine.
+//
+// Prefix must be set: without one every key of the database, including
+// audit streams, refresh families and denylist entries, would be reported
+// as a session.
+//
+// Redis does not replay notifications: events emitted while no watcher is
+// connected are lost. With a cluster client only the node serving the
+// subscription is watched.
+func (s *RedisStore) WatchEvents(ctx context.Context) error {
+	if s.Prefix == "" {
+		return errWatchNoPrefix
+	}
+	pubsub := s.Client.PSubscribe(ctx, keyspaceEventPatterns...)
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			s.dispatchKeyEvent(msg.Channel, msg.Payload)
+		}
+	}
+}
+
+// dispatchKeyEvent turns a keyevent notification for key into a
+// SessionEvent, ignoring keys that are not sessions of this store. Session
+// IDs are alphanumeric, so keys with a ':' after Prefix belong to the user
+// index or to other data sharing the prefix.
+func (s *RedisStore) dispatchKeyEvent(channel, key string) {
+	var typ SessionEventType
+	switch channel[strings.LastIndexByte(channel, ':')+1:] {
+	case "expired":
+		typ = SessionExpired
+	case "del":
+		typ = SessionDeleted
+	default:
+		return
+	}
+	id, ok := strings.CutPrefix(key, s.Prefix)
+	if !ok || id == "" || strings.ContainsRune(id, ':') {
+		return
+	}
+	if s.HashTag {
+		id = strings.TrimSuffix(strings.TrimPrefix(id, "{"), "}")
+	}
+	if typ == SessionExpired && s.Audit != nil {
+		s.auditExpire(id)
+	}
+	event := SessionEvent{Type: typ, ID: id, Time: time.Now()}
+	// Callbacks run without the lock, so they may call OnEvent.
+	s.listenerMutex.RLock()
+	listeners := make([]func(SessionEvent), len(s.listeners))
+	copy(listeners, s.listeners)
+	s.listenerMutex.RUnlock()
+	for _, fn := range listeners {
+		fn(event)
+	}
+}
@@ -0,0 +1,238 @@
+package cartsess
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisLayout selects how RedisStore lays out a session in redis.
+type RedisLayout int
+
+const (
+	// RedisLayoutString stores the serialized session as one string value.
+	RedisLayoutString RedisLayout = iota
+	// RedisLayoutHash stores one hash per session. Each user value is a
+	// field prefixed with HashValuePrefix, next to the reserved metadata
+	// fields, so sessions can be inspected and individual values changed
+	// without decoding the whole session.
+	RedisLayoutHash
+)
+
+// HashValuePrefix prefixes the hash fields holding user values in
+// RedisLayoutHash, keeping them apart from the metadata fields.
+const HashValuePrefix = "v:"
+
+// Reserved metadata fields of a RedisLayoutHash session.
+const (
+	MetaCreatedAt = "created_at"
+	MetaLastSeen  = "last_seen"
+	MetaUserID    = "user_id"
+	MetaIP        = "ip"
+	MetaUserAgent = "user_agent"
+	MetaVersion   = "version"
+)
+
+// SessionMeta is the bookkeeping kept next to the session values.
+type SessionMeta struct {
+	CreatedAt time.Time
+	LastSeen  time.Time
+	UserID    string
+	IP        string
+	UserAgent string
+	Version   int64 // incremented on every save
+}
+
+func (s *RedisStore) decodeHash(fields map[string]string, session *Session) error {
+	var err error
+	session.loadedFields = session.loadedFields[:0]
+	for field, raw := range fields {
+		if key, ok := strings.CutPrefix(field, HashValuePrefix); ok {
+			session.loadedFields = append(session.loadedFields, field)
+			val, _err := s.decodeHashValue(session.ID, key, raw)
+			if _err != nil {
+				err = _err
+				continue
+			}
+			session.Values[key] = val
+		}
+	}
+	session.Meta = parseSessionMeta(fields)
+	return err
+}
+
+// decodeHashValue decodes a single value. Each value is serialized on its
+// own as a one-entry session with the ID of the session it belongs to, so
+// any Serializer can be used.
+func (s *RedisStore) decodeHashValue(id, key, raw string) (interface{}, error) {
+	tmp := &Session{ID: id, Values: make(map[string]interface{})}
+	if err := s.Serializer.Deserialize([]byte(raw), tmp); err != nil {
+		return nil, err
+	}
+	return tmp.Values[key], nil
+}
+
+func (s *RedisStore) encodeHashValue(id, key string, val interface{}) (string, error) {
+	tmp := &Session{ID: id, Values: map[string]interface{}{key: val}}
+	b, err := s.Serializer.Serialize(tmp)
+	return string(b), err
+}
+
+func parseSessionMeta(fields map[string]string) *SessionMeta {
+	meta := &SessionMeta{
+		UserID:    fields[MetaUserID],
+		IP:        fields[MetaIP],
+		UserAgent: fields[MetaUserAgent],
+	}
+	if v, err := strconv.ParseInt(fields[MetaCreatedAt], 10, 64); err == nil {
+		meta.CreatedAt = time.Unix(v, 0)
+	}
+	if v, err := strconv.ParseInt(fields[MetaLastSeen], 10, 64); err == nil {
+		meta.LastSeen = time.Unix(v, 0)
+	}
+	meta.Version, _ = strconv.ParseInt(fields[MetaVersion], 10, 64)
+	return meta
+}
+
+// hashWriter prepares rewriting the session hash, keeping created_at and
+// bumping version. saved updates the metadata and loaded fields of session
+// and must only be called once the write succeeded.
+func (s *RedisStore) hashWriter(r *http.Request, session *Session) (write func(ctx context.Context, pipe redis.Pipeliner), saved func(), err error) {
+	now := time.Now()
+	meta := &SessionMeta{CreatedAt: now}
+	if session.Meta != nil {
+		*meta = *session.Meta
+	}
+	meta.LastSeen = now
+	meta.IP = clientIP(r)
+	meta.UserAgent = r.UserAgent()
+	meta.UserID = s.userID(session)
+	meta.Version++
+
+	fields := make([]string, 0, len(session.Values))
+	values := make([]interface{}, 0, 2*len(session.Values)+10)
+	for k, v := range session.Values {
+		raw, err := s.encodeHashValue(session.ID, k, v)
+		if err != nil {
+			return nil, nil, err
+		}
+		fields = append(fields, HashValuePrefix+k)
+		values = append(values, HashValuePrefix+k, raw)
+	}
+	values = append(values,
+		MetaCreatedAt, meta.CreatedAt.Unix(),
+		MetaLastSeen, meta.LastSeen.Unix(),
+		MetaUserID, meta.UserID,
+		MetaIP, meta.IP,
+		MetaUserAgent, meta.UserAgent,
+	)
+	removed := removedFields(session.loadedFields, fields)
+
+	write = func(ctx context.Context, pipe redis.Pipeliner) {
+		key := s.sessionKey(session.ID)
+		if len(removed) > 0 {
+			pipe.HDel(ctx, key, removed...)
+		}
+		pipe.HSet(ctx, key, values...)
+		pipe.HIncrBy(ctx, key, MetaVersion, 1)
+		if s.Options.MaxAge > 0 {
+			pipe.Expire(ctx, key, time.Duration(s.Options.MaxAge)*time.Second)
+		}
+	}
+	saved = func() {
+		session.Meta = meta
+		session.loadedFields = fields
+	}
+	return write, saved, nil
+}
+
+// removedFields lists the loaded value fields that are no longer present.
+func removedFields(loaded, current []string) []string {
+	keep := make(map[string]bool, len(current))
+	for _, f := range current {
+		keep[f] = true
+	}
+	var fields []string
+	for _, f := range loaded {
+		if !keep[f] {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// GetMeta returns the metadata of the session with the given ID without
+// decoding its values. It requires RedisLayoutHash.
+func (s *RedisStore) GetMeta(ctx context.Context, id string) (*SessionMeta, error) {
+	fields, err := s.Client.HMGet(ctx, s.sessionKey(id),
+		MetaCreatedAt, MetaLastSeen, MetaUserID, MetaIP, MetaUserAgent, MetaVersion).Result()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(fields))
+	for i, name := range []string{MetaCreatedAt, MetaLastSeen, MetaUserID, MetaIP, MetaUserAgent, MetaVersion} {
+		if v, ok := fields[i].(string); ok {
+			m[name] = v
+		}
+	}
+	if len(m) == 0 {
+		return nil, ErrNotFound
+	}
+	return parseSessionMeta(m), nil
+}
+
+// setValueScript sets a field of a session hash if the session still
+// exists, so an expired session is not recreated without a TTL.
+var setValueScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+// SetValue sets a single value of a stored session in place. It requires
+// RedisLayoutHash.
+func (s *RedisStore) SetValue(ctx context.Context, id, key string, val interface{}) error {
+	raw, err := s.encodeHashValue(id, key, val)
+	if err != nil {
+		return err
+	}
+	n, err := setValueScript.Run(ctx, s.Client, []string{s.sessionKey(id)}, HashValuePrefix+key, raw).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteValue removes a single value of a stored session in place. It
+// requires RedisLayoutHash.
+func (s *RedisStore) DeleteValue(ctx context.Context, id, key string) error {
+	return s.Client.HDel(ctx, s.sessionKey(id), HashValuePrefix+key).Err()
+}
+
+// Expire changes the remaining lifetime of a stored session.
+func (s *RedisStore) Expire(ctx context.Context, id string, ttl time.Duration) error {
+	ok, err := s.Client.Expire(ctx, s.sessionKey(id), ttl).Result()
+	if err == nil && !ok {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// clientIP returns the host part of the request's remote address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
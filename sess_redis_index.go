@@ -0,0 +1,142 @@
+package cartsess
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// userIndexPrefix follows Prefix in the keys of the per-user indexes. It
+// cannot clash with generated session IDs, which are alphanumeric.
+const userIndexPrefix = "user:"
+
+// userIndexKey returns the key of the sorted set indexing the sessions of
+// userID. Members are session IDs scored by the time of their last save.
+func (s *RedisStore) userIndexKey(userID string) string {
+	if s.HashTag {
+		return s.Prefix + userIndexPrefix + "{" + userID + "}"
+	}
+	return s.Prefix + userIndexPrefix + userID
+}
+
+// userID returns the user ID stored in the session under UserIDKey, or ""
+// when UserIDKey is unset or the session has no user.
+func (s *RedisStore) userID(session *Session) string {
+	if s.UserIDKey == "" {
+		return ""
+	}
+	if v, ok := session.Values[s.UserIDKey]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// indexUser queues the index updates for a saved session: it is added to
+// the set of its current user and removed from the set of the user it was
+// loaded with, if that changed. Save records the new user once the
+// transaction succeeded.
+func (s *RedisStore) indexUser(ctx context.Context, pipe redis.Pipeliner, session *Session) {
+	uid := s.userID(session)
+	if session.indexedUserID != "" && session.indexedUserID != uid {
+		pipe.ZRem(ctx, s.userIndexKey(session.indexedUserID), session.ID)
+	}
+	if uid != "" {
+		key := s.userIndexKey(uid)
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(time.Now().Unix()), Member: session.ID})
+		if s.Options.MaxAge > 0 {
+			pipe.Expire(ctx, key, time.Duration(s.Options.MaxAge)*time.Second)
+		}
+	}
+}
+
+// unindexUser queues removing a destroyed session from its user's set.
+// Destroy forgets the indexed user once the transaction succeeded.
+func (s *RedisStore) unindexUser(ctx context.Context, pipe redis.Pipeliner, session *Session) {
+	uid := session.indexedUserID
+	if uid == "" {
+		uid = s.userID(session)
+	}
+	if uid != "" {
+		pipe.ZRem(ctx, s.userIndexKey(uid), session.ID)
+	}
+}
+
+// ListUserSessions returns the IDs of the live sessions of userID, most
+// recently saved first. It requires UserIndex.
+//
+// IDs whose session key has expired are removed from the index as they
+// are found.
+func (s *RedisStore) ListUserSessions(ctx context.Context, userID string) ([]string, error) {
+	key := s.userIndexKey(userID)
+	if s.Options.MaxAge > 0 {
+		min := time.Now().Unix() - int64(s.Options.MaxAge)
+		s.Client.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(min, 10))
+	}
+	ids, err := s.Client.ZRevRange(ctx, key, 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	exists := make([]*redis.IntCmd, len(ids))
+	_, err = s.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			exists[i] = pipe.Exists(ctx, s.sessionKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	live := ids[:0]
+	var dangling []interface{}
+	for i, id := range ids {
+		if exists[i].Val() > 0 {
+			live = append(live, id)
+		} else {
+			dangling = append(dangling, id)
+		}
+	}
+	if len(dangling) > 0 {
+		s.Client.ZRem(ctx, key, dangling...)
+	}
+	return live, nil
+}
+
+// RevokeUserSessions deletes every session of userID except the one with
+// ID except, which may be empty, and returns how many were deleted. It
+// requires UserIndex.
+func (s *RedisStore) RevokeUserSessions(ctx context.Context, userID string, except string) (int, error) {
+	key := s.userIndexKey(userID)
+	ids, err := s.Client.ZRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	var members []interface{}
+	for _, id := range ids {
+		if id != except {
+			members = append(members, id)
+		}
+	}
+	if len(members) == 0 {
+		return 0, nil
+	}
+	// One DEL per session: with a cluster the keys live in different slots.
+	deleted := make([]*redis.IntCmd, len(members))
+	_, err = s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range members {
+			deleted[i] = pipe.Del(ctx, s.sessionKey(id.(string)))
+		}
+		pipe.ZRem(ctx, key, members...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, cmd := range deleted {
+		n += int(cmd.Val())
+	}
+	return n, nil
+}
@@ -0,0 +1,402 @@
+package cartsess
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisStore_DispatchKeyEvent(t *testing.T) {
+	store := NewRedisStore()
+	store.Prefix = "sess:"
+	var events []SessionEvent
+	store.OnEvent(func(e SessionEvent) {
+		events = append(events, e)
+	})
+
+	store.dispatchKeyEvent("__keyevent@0__:expired", "sess:abc")
+	store.dispatchKeyEvent("__keyevent@0__:del", "sess:def")
+	store.dispatchKeyEvent("__keyevent@0__:del", "other:ghi")
+	store.dispatchKeyEvent("__keyevent@0__:expired", "sess:user:42")
+	store.dispatchKeyEvent("__keyevent@0__:del", "sess:refresh:abc")
+	store.dispatchKeyEvent("__keyevent@0__:set", "sess:abc")
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].Type != SessionExpired || events[0].ID != "abc" {
+		t.Errorf("unexpected first event %+v", events[0])
+	}
+	if events[1].Type != SessionDeleted || events[1].ID != "def" {
+		t.Errorf("unexpected second event %+v", events[1])
+	}
+}
+
+func TestRedisStore_WatchEvents(t *testing.T) {
+	store := NewRedisStore()
+	if err := store.WatchEvents(context.Background()); !errors.Is(err, errWatchNoPrefix) {
+		t.Errorf("expected WatchEvents to require a Prefix, got %v", err)
+	}
+
+	// A callback registering another one must not deadlock.
+	store.Prefix = "sess:"
+	calls := 0
+	store.OnEvent(func(e SessionEvent) {
+		calls++
+		store.OnEvent(func(SessionEvent) { calls++ })
+	})
+	done := make(chan struct{})
+	go func() {
+		store.dispatchKeyEvent("__keyevent@0__:del", "sess:abc")
+		store.dispatchKeyEvent("__keyevent@0__:del", "sess:def")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch deadlocked")
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestRedisStore_HashTagKeys(t *testing.T) {
+	store := NewRedisStore()
+	store.Prefix = "sess:"
+	if got := store.sessionKey("abc"); got != "sess:abc" {
+		t.Errorf("expected untagged key, got %s", got)
+	}
+
+	store.HashTag = true
+	if got := store.sessionKey("abc"); got != "sess:{abc}" {
+		t.Errorf("expected tagged key, got %s", got)
+	}
+	if got := store.userIndexKey("42"); got != "sess:user:{42}" {
+		t.Errorf("expected tagged index key, got %s", got)
+	}
+
+	var ids []string
+	store.OnEvent(func(e SessionEvent) {
+		ids = append(ids, e.ID)
+	})
+	store.dispatchKeyEvent("__keyevent@0__:expired", store.sessionKey("abc"))
+	if len(ids) != 1 || ids[0] != "abc" {
+		t.Errorf("expected event for 'abc', got %v", ids)
+	}
+}
+
+func TestAuditLog_ChangedKeysRedacted(t *testing.T) {
+	audit := NewAuditLog(nil, "audit")
+	audit.Redact = RedactExcept("role")
+
+	keys := audit.changedKeys(
+		map[string]interface{}{"role": "user", "email": "a@example.com", "cart": 1},
+		map[string]interface{}{"role": "admin", "email": "b@example.com", "cart": 1, "new": true},
+	)
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 changed keys, got %v", keys)
+	}
+	if keys["role"] != "admin" {
+		t.Errorf("expected allowed value to be recorded, got %q", keys["role"])
+	}
+	if keys["email"] != Redacted || keys["new"] != Redacted {
+		t.Errorf("expected other values to be redacted, got %v", keys)
+	}
+}
+
+// newTestRedisStore returns a RedisStore backed by an in-process redis.
+func newTestRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	store := NewRedisStoreWithClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	store.Prefix = "sess:"
+	return store, mr
+}
+
+// saveRedis saves session and returns a request carrying its cookie.
+func saveRedis(t *testing.T, store *RedisStore, session *Session) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	if err := session.Save(req, rec); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	req = httptest.NewRequest("GET", "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestRedisStore_HashLayout(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	store.Layout = RedisLayoutHash
+	store.UserIDKey = "user_id"
+
+	session, _ := store.Get(httptest.NewRequest("GET", "/", nil), "sess")
+	session.Values["user_id"] = "42"
+	session.Values["cart"] = "apple"
+	session.Values["coupon"] = "SUMMER"
+	req := saveRedis(t, store, session)
+
+	key := store.sessionKey(session.ID)
+	if got := mr.HGet(key, MetaUserID); got != "42" {
+		t.Errorf("expected user_id metadata, got %q", got)
+	}
+	if mr.HGet(key, HashValuePrefix+"cart") == "" || mr.HGet(key, MetaVersion) != "1" {
+		t.Errorf("expected the cart field and version 1, got version %q", mr.HGet(key, MetaVersion))
+	}
+	if mr.TTL(key) <= 0 {
+		t.Error("expected the session hash to expire")
+	}
+
+	loaded, err := store.Get(req, "sess")
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if loaded.IsNew || loaded.Values["cart"] != "apple" || loaded.Values["coupon"] != "SUMMER" {
+		t.Errorf("unexpected values %v", loaded.Values)
+	}
+	if loaded.Meta == nil || loaded.Meta.UserID != "42" || loaded.Meta.Version != 1 || loaded.Meta.CreatedAt.IsZero() {
+		t.Errorf("unexpected metadata %+v", loaded.Meta)
+	}
+
+	// Removed values are deleted from the hash, others are kept.
+	delete(loaded.Values, "coupon")
+	saveRedis(t, store, loaded)
+	if mr.HGet(key, HashValuePrefix+"coupon") != "" {
+		t.Error("expected the removed value to be deleted")
+	}
+	if mr.HGet(key, HashValuePrefix+"cart") == "" || mr.HGet(key, MetaVersion) != "2" {
+		t.Errorf("expected the cart field and version 2, got version %q", mr.HGet(key, MetaVersion))
+	}
+
+	meta, err := store.GetMeta(context.Background(), session.ID)
+	if err != nil || meta.Version != 2 {
+		t.Errorf("unexpected metadata %+v, %v", meta, err)
+	}
+}
+
+func TestRedisStore_SetValue(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	store.Layout = RedisLayoutHash
+	ctx := context.Background()
+
+	session, _ := store.Get(httptest.NewRequest("GET", "/", nil), "sess")
+	session.Values["cart"] = "apple"
+	req := saveRedis(t, store, session)
+
+	if err := store.SetValue(ctx, session.ID, "cart", "pear"); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	if loaded, _ := store.Get(req, "sess"); loaded.Values["cart"] != "pear" {
+		t.Errorf("expected the value to change, got %v", loaded.Values)
+	}
+
+	// A missing session is not recreated.
+	if err := store.SetValue(ctx, "missing", "cart", "pear"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if mr.Exists(store.sessionKey("missing")) {
+		t.Error("expected no key for a missing session")
+	}
+}
+
+func TestRedisStore_UserIndex(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	store.UserIndex = true
+	store.UserIDKey = "user_id"
+	ctx := context.Background()
+
+	var ids []string
+	var reqs []*http.Request
+	for i := 0; i < 3; i++ {
+		session, _ := store.Get(httptest.NewRequest("GET", "/", nil), "sess")
+		session.Values["user_id"] = 42
+		reqs = append(reqs, saveRedis(t, store, session))
+		ids = append(ids, session.ID)
+	}
+	if live, err := store.ListUserSessions(ctx, "42"); err != nil || len(live) != 3 {
+		t.Fatalf("expected 3 sessions, got %v, %v", live, err)
+	}
+
+	// A session switching users moves to the other index.
+	session, _ := store.Get(reqs[2], "sess")
+	session.Values["user_id"] = 7
+	saveRedis(t, store, session)
+	if live, _ := store.ListUserSessions(ctx, "7"); len(live) != 1 || live[0] != ids[2] {
+		t.Errorf("expected the session under user 7, got %v", live)
+	}
+
+	// Expired sessions are pruned from the index: by key and by score.
+	mr.Del(store.sessionKey(ids[1]))
+	mr.ZAdd(store.userIndexKey("42"), float64(time.Now().Add(-31*24*time.Hour).Unix()), "stale")
+	live, err := store.ListUserSessions(ctx, "42")
+	if err != nil || len(live) != 1 || live[0] != ids[0] {
+		t.Errorf("expected only %s, got %v, %v", ids[0], live, err)
+	}
+	if members, _ := mr.ZMembers(store.userIndexKey("42")); len(members) != 1 {
+		t.Errorf("expected dangling IDs to be removed, got %v", members)
+	}
+
+	// Revoking keeps the current session.
+	session, _ = store.Get(httptest.NewRequest("GET", "/", nil), "sess")
+	session.Values["user_id"] = 42
+	saveRedis(t, store, session)
+	n, err := store.RevokeUserSessions(ctx, "42", session.ID)
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 revoked session, got %d, %v", n, err)
+	}
+	if mr.Exists(store.sessionKey(ids[0])) || !mr.Exists(store.sessionKey(session.ID)) {
+		t.Error("expected only the other session to be deleted")
+	}
+}
+
+func TestRedisStore_FailedSaveKeepsSession(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	store.Layout = RedisLayoutHash
+	store.UserIndex = true
+	store.UserIDKey = "user_id"
+
+	session, _ := store.Get(httptest.NewRequest("GET", "/", nil), "sess")
+	session.Values["user_id"] = 42
+	session.Values["cart"] = "apple"
+	req := saveRedis(t, store, session)
+	loaded, _ := store.Get(req, "sess")
+
+	mr.Close()
+	delete(loaded.Values, "cart")
+	loaded.Values["user_id"] = 7
+	if err := loaded.Save(req, httptest.NewRecorder()); err == nil {
+		t.Fatal("expected the save to fail")
+	}
+	if loaded.Meta.Version != 1 || len(loaded.loadedFields) != 2 || loaded.indexedUserID != "42" {
+		t.Errorf("expected the session to keep its loaded state, got %+v, %v, %q", loaded.Meta, loaded.loadedFields, loaded.indexedUserID)
+	}
+}
+
+func TestAuditLog_ExpireRecordedOnce(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	store.Audit = NewAuditLog(store.Client, "audit")
+	replica := NewRedisStoreWithClient(store.Client)
+	replica.Prefix = store.Prefix
+	replica.Audit = store.Audit
+
+	// Every watcher receives the notification.
+	for _, s := range []*RedisStore{store, replica} {
+		s.dispatchKeyEvent("__keyevent@0__:expired", store.sessionKey("abc"))
+	}
+	events, err := store.Audit.Range(context.Background(), "-", "+", 10)
+	if err != nil || len(events) != 1 || events[0].Type != AuditExpire || events[0].SessionID != "abc" {
+		t.Errorf("expected one expire event, got %+v, %v", events, err)
+	}
+	if !mr.Exists("audit:expired:abc") {
+		t.Error("expected the expiry marker to be set")
+	}
+}
+
+// appendAfterEmptyRead appends an entry to a stream after the first XREAD
+// that returns nothing, between two reads of AuditLog.Follow.
+type appendAfterEmptyRead struct {
+	mr       *miniredis.Miniredis
+	appended bool
+}
+
+func (h *appendAfterEmptyRead) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *appendAfterEmptyRead) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if cmd.Name() == "xread" && err == redis.Nil && !h.appended {
+			h.appended = true
+			h.mr.XAdd("audit", "*", []string{"type", "create", "sid", "abc"})
+		}
+		return err
+	}
+}
+
+func (h *appendAfterEmptyRead) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestAuditLog_FollowBetweenReads(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	client.AddHook(&appendAfterEmptyRead{mr: mr})
+	mr.XAdd("audit", "*", []string{"type", "create", "sid", "old"})
+	audit := NewAuditLog(client, "audit")
+	audit.block = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var got []string
+	audit.Follow(ctx, "$", func(event AuditEvent) {
+		got = append(got, event.SessionID)
+		cancel()
+	})
+	if len(got) != 1 || got[0] != "abc" {
+		t.Errorf("expected the event appended between reads, got %v", got)
+	}
+}
+
+func TestRedisStore_SaveAndRegenerate(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	store.UserIndex = true
+	store.UserIDKey = "user_id"
+	store.Audit = NewAuditLog(store.Client, "audit")
+	store.Audit.IncludeKeys = true
+	ctx := context.Background()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	session, _ := store.Get(req, "sess")
+	session.Values["cart"] = "apple"
+	saveRedis(t, store, session)
+	if session.IsNew {
+		t.Error("expected a saved session not to be new")
+	}
+
+	// Saving the same session again is an update, not a second create.
+	session.Values["user_id"] = 42
+	saveRedis(t, store, session)
+
+	oldID := session.ID
+	rec := httptest.NewRecorder()
+	if err := store.Regenerate(req, rec, session); err != nil {
+		t.Fatalf("failed to regenerate: %v", err)
+	}
+	if session.ID == oldID || rec.Result().Cookies()[0].Value != session.ID {
+		t.Fatalf("expected a new ID in the cookie, got %q", session.ID)
+	}
+	if mr.Exists(store.sessionKey(oldID)) || !mr.Exists(store.sessionKey(session.ID)) {
+		t.Error("expected the session to move to the new key")
+	}
+	if ids, _ := store.ListUserSessions(ctx, "42"); len(ids) != 1 || ids[0] != session.ID {
+		t.Errorf("expected only the new ID in the index, got %v", ids)
+	}
+
+	events, _ := store.Audit.Range(ctx, "-", "+", 10)
+	var types []AuditEventType
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	want := []AuditEventType{AuditCreate, AuditUpdate, AuditRegenerate}
+	if len(types) != len(want) {
+		t.Fatalf("expected events %v, got %v", want, types)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("expected events %v, got %v", want, types)
+			break
+		}
+	}
+	if events[1].Keys["user_id"] != Redacted || len(events[1].Keys) != 1 {
+		t.Errorf("expected the update to record user_id only, got %v", events[1].Keys)
+	}
+}
@@ -0,0 +1,255 @@
+package cartsess
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Invalidator broadcasts cache invalidations between TieredStore instances.
+type Invalidator interface {
+	// Publish announces that the cached entry for key is stale.
+	Publish(ctx context.Context, message string) error
+	// Subscribe calls fn for every published message until ctx is done.
+	Subscribe(ctx context.Context, fn func(message string)) error
+}
+
+// RedisInvalidator is an Invalidator using redis pub/sub.
+type RedisInvalidator struct {
+	Client  redis.UniversalClient
+	Channel string
+}
+
+var _ Invalidator = &RedisInvalidator{}
+
+func NewRedisInvalidator(client redis.UniversalClient) *RedisInvalidator {
+	return &RedisInvalidator{
+		Client:  client,
+		Channel: prefixKey + "invalidate",
+	}
+}
+
+func (i *RedisInvalidator) Publish(ctx context.Context, message string) error {
+	return i.Client.Publish(ctx, i.Channel, message).Err()
+}
+
+func (i *RedisInvalidator) Subscribe(ctx context.Context, fn func(message string)) error {
+	pubsub := i.Client.Subscribe(ctx, i.Channel)
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			fn(msg.Payload)
+		}
+	}
+}
+
+type tieredEntry struct {
+	key     string
+	session *Session
+	expires time.Time
+}
+
+// TieredStore serves sessions from a bounded in-process cache in front of
+// a backing server-side Store such as RedisStore.
+//
+// Reads go through the cache and fall back to the backend; saves and
+// destroys are written to the backend first and then to the cache. When an
+// Invalidator is set, every write is broadcast so other instances drop
+// their copy; run Watch to receive those broadcasts.
+//
+// Only sessions with an ID are cached, keyed by cookie name and ID; with a
+// stateless backend such as CookieStore or JWTStore every read goes to the
+// backend.
+//
+// Cached sessions are shallow copies: values holding pointers, maps or
+// slices are shared with the cache and must not be mutated in place.
+type TieredStore struct {
+	Backend     Store
+	Size        int           // maximum number of cached sessions
+	TTL         time.Duration // how long an entry may be served without asking the backend
+	Invalidator Invalidator
+
+	instanceID string
+	mutex      sync.Mutex
+	entries    map[string]*list.Element
+	lru        *list.List
+}
+
+var _ Store = &TieredStore{}
+
+func NewTieredStore(backend Store, size int) *TieredStore {
+	return &TieredStore{
+		Backend:    backend,
+		Size:       size,
+		TTL:        time.Minute,
+		instanceID: generateID(32),
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (s *TieredStore) Get(r *http.Request, cookieName string) (session *Session, err error) {
+	session, err = s.New(r, cookieName)
+	session.cookieName = cookieName
+	session.store = s
+	return
+}
+
+func (s *TieredStore) New(r *http.Request, cookieName string) (*Session, error) {
+	if c, errCookie := r.Cookie(cookieName); errCookie == nil && c.Value != "" {
+		if cached := s.lookup(cacheKey(cookieName, c.Value)); cached != nil {
+			cached.store = s
+			return cached, nil
+		}
+	}
+	session, err := s.Backend.New(r, cookieName)
+	if session == nil {
+		return session, err
+	}
+	session.store = s
+	if err == nil && !session.IsNew {
+		s.remember(session)
+	}
+	return session, err
+}
+
+// Save adds a single session to the response.
+func (s *TieredStore) Save(r *http.Request, w http.ResponseWriter, session *Session) error {
+	if err := s.Backend.Save(r, w, session); err != nil {
+		s.forget(cacheKey(session.CookieName(), session.ID))
+		return err
+	}
+	s.remember(session)
+	s.publish(cacheKey(session.CookieName(), session.ID))
+	return nil
+}
+
+func (s *TieredStore) Destroy(r *http.Request, w http.ResponseWriter, session *Session) error {
+	key := cacheKey(session.CookieName(), session.ID)
+	s.forget(key)
+	err := s.Backend.Destroy(r, w, session)
+	s.publish(key)
+	return err
+}
+
+// Watch evicts entries invalidated by other instances. It blocks until ctx
+// is done or the subscription fails, so run it in its own goroutine.
+func (s *TieredStore) Watch(ctx context.Context) error {
+	if s.Invalidator == nil {
+		return nil
+	}
+	return s.Invalidator.Subscribe(ctx, func(message string) {
+		origin, key, ok := strings.Cut(message, " ")
+		if ok && origin != s.instanceID {
+			s.forget(key)
+		}
+	})
+}
+
+func (s *TieredStore) publish(key string) {
+	if s.Invalidator == nil || key == "" {
+		return
+	}
+	ctx, cancel := Context()
+	defer cancel()
+	if err := s.Invalidator.Publish(ctx, s.instanceID+" "+key); err != nil {
+		log.Printf(errorFormat, err)
+	}
+}
+
+// cacheKey returns the cache key of the session with the given ID, or ""
+// when the session has no ID and must not be cached.
+func cacheKey(cookieName, id string) string {
+	if id == "" {
+		return ""
+	}
+	return cookieName + ":" + id
+}
+
+func (s *TieredStore) lookup(key string) *Session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := el.Value.(*tieredEntry)
+	if s.TTL > 0 && time.Now().After(entry.expires) {
+		s.lru.Remove(el)
+		delete(s.entries, key)
+		return nil
+	}
+	s.lru.MoveToFront(el)
+	return cloneSession(entry.session)
+}
+
+func (s *TieredStore) remember(session *Session) {
+	key := cacheKey(session.CookieName(), session.ID)
+	if s.Size <= 0 || key == "" {
+		return
+	}
+	entry := &tieredEntry{
+		key:     key,
+		session: cloneSession(session),
+		expires: time.Now().Add(s.TTL),
+	}
+	entry.session.IsNew = false
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if el, ok := s.entries[key]; ok {
+		el.Value = entry
+		s.lru.MoveToFront(el)
+		return
+	}
+	s.entries[key] = s.lru.PushFront(entry)
+	for s.lru.Len() > s.Size {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*tieredEntry).key)
+	}
+}
+
+func (s *TieredStore) forget(key string) {
+	if key == "" {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.lru.Remove(el)
+		delete(s.entries, key)
+	}
+}
+
+// cloneSession returns a copy of session with its own Values map and
+// Options, so the cached copy is not affected by handlers.
+func cloneSession(session *Session) *Session {
+	c := *session
+	c.Values = copyValues(session.Values)
+	if session.Options != nil {
+		opts := *session.Options
+		c.Options = &opts
+	}
+	if session.Meta != nil {
+		meta := *session.Meta
+		c.Meta = &meta
+	}
+	c.loadedFields = append([]string(nil), session.loadedFields...)
+	return &c
+}
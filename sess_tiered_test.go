@@ -0,0 +1,133 @@
+package cartsess
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type countingStore struct {
+	*MemoryStore
+	loads int
+}
+
+func (s *countingStore) New(r *http.Request, name string) (*Session, error) {
+	s.loads++
+	return s.MemoryStore.New(r, name)
+}
+
+type localInvalidator struct {
+	subscribers []func(string)
+}
+
+func (i *localInvalidator) Publish(ctx context.Context, message string) error {
+	for _, fn := range i.subscribers {
+		fn(message)
+	}
+	return nil
+}
+
+func (i *localInvalidator) Subscribe(ctx context.Context, fn func(string)) error {
+	i.subscribers = append(i.subscribers, fn)
+	return nil
+}
+
+func TestTieredStore_ReadThrough(t *testing.T) {
+	backend := &countingStore{MemoryStore: NewMemoryStore()}
+	store := NewTieredStore(backend, 10)
+
+	req1 := httptest.NewRequest("GET", "/", nil)
+	rec1 := httptest.NewRecorder()
+	session, _ := store.Get(req1, "tiered")
+	session.Values["cart"] = "apple"
+	if err := session.Save(req1, rec1); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	req2 := httptest.NewRequest("GET", "/", nil)
+	req2.AddCookie(rec1.Result().Cookies()[0])
+	loaded, err := store.Get(req2, "tiered")
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	if loaded.Values["cart"] != "apple" {
+		t.Errorf("expected cart 'apple', got %v", loaded.Values["cart"])
+	}
+	if backend.loads != 1 {
+		t.Errorf("expected cached read, backend loaded %d times", backend.loads)
+	}
+
+	// Mutating the returned session must not leak into the cache.
+	loaded.Values["cart"] = "pear"
+	again, _ := store.Get(req2, "tiered")
+	if again.Values["cart"] != "apple" {
+		t.Errorf("expected cache to be isolated, got %v", again.Values["cart"])
+	}
+}
+
+func TestTieredStore_Invalidation(t *testing.T) {
+	backend := &countingStore{MemoryStore: NewMemoryStore()}
+	invalidator := &localInvalidator{}
+	a := NewTieredStore(backend, 10)
+	b := NewTieredStore(backend, 10)
+	a.Invalidator = invalidator
+	b.Invalidator = invalidator
+	a.Watch(context.Background())
+	b.Watch(context.Background())
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := a.Get(req, "tiered")
+	session.Values["n"] = 1
+	session.Save(req, rec)
+	cookie := rec.Result().Cookies()[0]
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	onB, _ := b.Get(req, "tiered")
+	onB.Values["n"] = 2
+	onB.Save(req, httptest.NewRecorder())
+
+	onA, _ := a.Get(req, "tiered")
+	if onA.Values["n"] != 2 {
+		t.Errorf("expected invalidated entry to be reloaded, got %v", onA.Values["n"])
+	}
+}
+
+func TestTieredStore_StatelessBackend(t *testing.T) {
+	store := NewTieredStore(NewJWTStore([]byte("test-secret-key")), 10)
+
+	req1 := httptest.NewRequest("GET", "/", nil)
+	rec1 := httptest.NewRecorder()
+	alice, _ := store.Get(req1, "sess")
+	alice.Values["user"] = "alice"
+	if err := alice.Save(req1, rec1); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	// Another client sending an empty cookie must not get alice's session.
+	req2 := httptest.NewRequest("GET", "/", nil)
+	req2.Header.Set("Cookie", "sess=")
+	other, _ := store.Get(req2, "sess")
+	if _, ok := other.Values["user"]; ok {
+		t.Errorf("expected empty session, got %v", other.Values)
+	}
+	if !other.IsNew {
+		t.Error("expected a new session for an empty cookie")
+	}
+
+	// alice still loads her own session from the backend.
+	req3 := httptest.NewRequest("GET", "/", nil)
+	req3.AddCookie(rec1.Result().Cookies()[0])
+	loaded, err := store.Get(req3, "sess")
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	if loaded.Values["user"] != "alice" {
+		t.Errorf("expected user 'alice', got %v", loaded.Values["user"])
+	}
+	if len(store.entries) != 0 {
+		t.Errorf("expected stateless sessions not to be cached, got %d entries", len(store.entries))
+	}
+}
@@ -10,12 +10,44 @@ type Session struct {
 	// user data.
 	ID string
 	// Values contains the user-data for the session.
-	Values     map[string]interface{}
+	Values map[string]interface{}
+	// Meta holds bookkeeping recorded by stores that track it, such as
+	// RedisStore with RedisLayoutHash. It is nil for other stores.
+	Meta       *SessionMeta
 	Options    *Options
 	IsNew      bool
 	store      Store
 	cookieName string
 	TTL        time.Duration
+	// cas is the compare-and-swap token of the loaded item, for stores
+	// that support optimistic concurrency.
+	cas uint64
+	// loadedFields are the hash fields holding values when the session
+	// was loaded or last saved, used to delete removed values.
+	loadedFields []string
+	// indexedUserID is the user the session was indexed under when loaded.
+	indexedUserID string
+	// needsSave is set by stores that want the session rewritten at the
+	// end of the request even if no value changed.
+	needsSave bool
+	// legacy is the session this one was migrated from by MigratingStore.
+	legacy *Session
+	// loadedValues is a copy of Values as loaded, kept to audit changes.
+	loadedValues map[string]interface{}
+	// regenerated is set when the session moved to a new ID.
+	regenerated bool
+	// tokenFamily is the refresh token family of a JWTStore session.
+	tokenFamily string
+	// startFamily is set by JWTStore.Login to start a refresh token family
+	// when the session is next saved.
+	startFamily bool
+	// tokenID and tokenExpiry are the jti and exp of the token a JWTStore
+	// session was loaded from or last saved as.
+	tokenID     string
+	tokenExpiry time.Time
+	// schemaVersion is the schema version of Values, tracked by managers
+	// with Migrations. 0 means unversioned and is not persisted.
+	schemaVersion int
 }
 
 func (s *Session) Save(r *http.Request, w http.ResponseWriter) error {
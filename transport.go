@@ -0,0 +1,220 @@
+package cartsess
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrAmbiguousToken is returned when a request carries different tokens
+// for the same session.
+var ErrAmbiguousToken = errors.New("ambiguous session token")
+
+var errTokenScheme = errors.New("session token header has the wrong scheme")
+
+// TokenTransport carries the tokens of stateless stores, such as JWTStore,
+// between client and server.
+type TokenTransport interface {
+	// Token returns the token of the session name sent with r, or "" if
+	// there is none.
+	Token(r *http.Request, name string) (string, error)
+	// SetToken hands token to the client. An empty token with a negative
+	// MaxAge asks the client to forget it, where the transport can.
+	SetToken(w http.ResponseWriter, name, token string, options *Options)
+}
+
+// CookieTransport carries tokens in a cookie named after the session. If
+// the client sends several, such as for different paths, the first is
+// used, like http.Request.Cookie does.
+type CookieTransport struct{}
+
+func (CookieTransport) Token(r *http.Request, name string) (string, error) {
+	if c, err := r.Cookie(name); err == nil {
+		return c.Value, nil
+	}
+	return "", nil
+}
+
+func (CookieTransport) SetToken(w http.ResponseWriter, name, token string, options *Options) {
+	http.SetCookie(w, NewCookie(name, token, options))
+}
+
+// HeaderTransport reads tokens from a request header and returns them in a
+// response header. A request repeating the header with different values is
+// rejected.
+type HeaderTransport struct {
+	Header         string // request header (default Authorization)
+	Scheme         string // scheme the token must follow, such as "Bearer"; empty for raw values
+	AllowRaw       bool   // also accept values without Scheme
+	ResponseHeader string // response header set to new tokens; empty to send none
+}
+
+// BearerTransport reads "Authorization: Bearer <token>" and returns new
+// tokens in X-JWT-Token.
+func BearerTransport() HeaderTransport {
+	return HeaderTransport{Scheme: "Bearer", ResponseHeader: "X-JWT-Token"}
+}
+
+func (t HeaderTransport) Token(r *http.Request, name string) (string, error) {
+	header := t.Header
+	if header == "" {
+		header = "Authorization"
+	}
+	var token string
+	for _, v := range r.Header.Values(header) {
+		v, err := t.parse(v)
+		if err != nil {
+			return "", err
+		}
+		if token != "" && v != token {
+			return "", ErrAmbiguousToken
+		}
+		token = v
+	}
+	return token, nil
+}
+
+// parse returns the token of a header value.
+func (t HeaderTransport) parse(v string) (string, error) {
+	if t.Scheme == "" {
+		return v, nil
+	}
+	scheme, token, ok := strings.Cut(v, " ")
+	if ok && strings.EqualFold(scheme, t.Scheme) {
+		return strings.TrimSpace(token), nil
+	}
+	if t.AllowRaw {
+		return v, nil
+	}
+	return "", errTokenScheme
+}
+
+func (t HeaderTransport) SetToken(w http.ResponseWriter, name, token string, options *Options) {
+	if t.ResponseHeader != "" && token != "" {
+		w.Header().Set(t.ResponseHeader, token)
+	}
+}
+
+// QueryTransport reads tokens from a query parameter, for clients that can
+// not set headers or cookies, such as browser WebSocket handshakes. Tokens
+// in URLs end up in logs and browser history, so they should be short
+// lived. Tokens are never written to responses.
+type QueryTransport struct {
+	Param string // query parameter (default access_token)
+}
+
+func (t QueryTransport) Token(r *http.Request, name string) (string, error) {
+	param := t.Param
+	if param == "" {
+		param = "access_token"
+	}
+	values := r.URL.Query()[param]
+	if len(values) == 0 {
+		return "", nil
+	}
+	for _, v := range values[1:] {
+		if v != values[0] {
+			return "", ErrAmbiguousToken
+		}
+	}
+	return values[0], nil
+}
+
+func (QueryTransport) SetToken(w http.ResponseWriter, name, token string, options *Options) {}
+
+// FormTransport reads tokens from a form field of POST requests, such as
+// refresh tokens sent to the refresh endpoint. Tokens are never written to
+// responses.
+type FormTransport struct {
+	Field string // form field (default refresh_token)
+}
+
+func (t FormTransport) Token(r *http.Request, name string) (string, error) {
+	field := t.Field
+	if field == "" {
+		field = "refresh_token"
+	}
+	return r.PostFormValue(field), nil
+}
+
+func (FormTransport) SetToken(w http.ResponseWriter, name, token string, options *Options) {}
+
+// MultiTransport combines transports. Tokens are read from the first
+// transport that has one, and written with every transport.
+type MultiTransport struct {
+	Transports []TokenTransport
+	// Strict rejects requests whose transports carry different tokens,
+	// such as a header and a cookie for different sessions, instead of
+	// using the first.
+	Strict bool
+}
+
+// Transports returns a MultiTransport reading from transports in order.
+func Transports(transports ...TokenTransport) *MultiTransport {
+	return &MultiTransport{Transports: transports}
+}
+
+// StrictTransports returns a MultiTransport reading from transports in
+// order, which rejects requests with different tokens.
+func StrictTransports(transports ...TokenTransport) *MultiTransport {
+	return &MultiTransport{Transports: transports, Strict: true}
+}
+
+func (t *MultiTransport) Token(r *http.Request, name string) (string, error) {
+	var token string
+	for _, transport := range t.Transports {
+		v, err := transport.Token(r, name)
+		if err != nil {
+			return "", err
+		}
+		if v == "" {
+			continue
+		}
+		if token == "" {
+			token = v
+			if !t.Strict {
+				break
+			}
+		} else if v != token {
+			return "", ErrAmbiguousToken
+		}
+	}
+	return token, nil
+}
+
+func (t *MultiTransport) SetToken(w http.ResponseWriter, name, token string, options *Options) {
+	for _, transport := range t.Transports {
+		transport.SetToken(w, name, token, options)
+	}
+}
+
+// refreshTransportFor returns the transport of refresh tokens matching
+// transport t of access tokens. Refresh tokens are never put in URLs, and
+// unknown transports get the header and cookie of the default.
+func refreshTransportFor(t TokenTransport) TokenTransport {
+	switch t := t.(type) {
+	case CookieTransport:
+		return t
+	case HeaderTransport:
+		return Transports(
+			HeaderTransport{Header: "X-Refresh-Token", ResponseHeader: "X-Refresh-Token"},
+			FormTransport{},
+		)
+	case QueryTransport:
+		return nil
+	case *MultiTransport:
+		m := &MultiTransport{Strict: t.Strict}
+		for _, transport := range t.Transports {
+			if rt := refreshTransportFor(transport); rt != nil {
+				m.Transports = append(m.Transports, rt)
+			}
+		}
+		return m
+	}
+	return refreshTransportFor(defaultTransport)
+}
+
+// defaultTransport reads "Authorization: Bearer <token>", then the
+// cookie, and writes both the cookie and X-JWT-Token. A request whose
+// header and cookie carry different tokens is rejected.
+var defaultTransport = StrictTransports(BearerTransport(), CookieTransport{})
@@ -0,0 +1,164 @@
+package cartsess
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTokenTransports(t *testing.T) {
+	request := func(header, cookie, query string) *http.Request {
+		req := httptest.NewRequest("GET", "/ws"+query, nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "jwt-session", Value: cookie})
+		}
+		return req
+	}
+
+	for _, tt := range []struct {
+		name      string
+		transport TokenTransport
+		req       *http.Request
+		want      string
+		err       error
+	}{
+		{"cookie", CookieTransport{}, request("Bearer a", "b", ""), "b", nil},
+		{"bearer", BearerTransport(), request("bearer a", "b", ""), "a", nil},
+		{"bearer rejects raw", BearerTransport(), request("a", "", ""), "", errTokenScheme},
+		{"custom header", HeaderTransport{Header: "X-Session"}, request("Bearer a", "", ""), "", nil},
+		{"query", QueryTransport{}, request("", "", "?access_token=q"), "q", nil},
+		{"query repeated", QueryTransport{}, request("", "", "?access_token=q&access_token=r"), "", ErrAmbiguousToken},
+		{"ordered", Transports(CookieTransport{}, BearerTransport()), request("Bearer a", "b", ""), "b", nil},
+		{"ordered fallback", Transports(CookieTransport{}, QueryTransport{}), request("", "", "?access_token=q"), "q", nil},
+		{"strict", StrictTransports(BearerTransport(), CookieTransport{}), request("Bearer a", "b", ""), "", ErrAmbiguousToken},
+		{"strict same token", StrictTransports(BearerTransport(), CookieTransport{}), request("Bearer a", "a", ""), "a", nil},
+		{"default rejects raw", defaultTransport, request("a", "", ""), "", errTokenScheme},
+		{"default strict", defaultTransport, request("Bearer a", "b", ""), "", ErrAmbiguousToken},
+		{"default same token", defaultTransport, request("Bearer a", "a", ""), "a", nil},
+	} {
+		got, err := tt.transport.Token(tt.req, "jwt-session")
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("%s: got %q, %v; want %q, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Add("X-Session", "a")
+	req.Header.Add("X-Session", "b")
+	if _, err := (HeaderTransport{Header: "X-Session"}).Token(req, "jwt-session"); !errors.Is(err, ErrAmbiguousToken) {
+		t.Errorf("expected a repeated header to be ambiguous, got %v", err)
+	}
+}
+
+func TestJWTStore_Transport(t *testing.T) {
+	store := NewJWTStore([]byte("test-secret-key"))
+	store.Transport = HeaderTransport{Header: "X-Session", ResponseHeader: "X-Session"}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "jwt-session")
+	session.Values["user"] = "gopher"
+	if err := session.Save(req, rec); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if len(rec.Result().Cookies()) != 0 || rec.Header().Get("X-JWT-Token") != "" {
+		t.Error("expected the token only in X-Session")
+	}
+	token := rec.Header().Get("X-Session")
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Session", token)
+	if session, err := store.Get(req, "jwt-session"); err != nil || session.Values["user"] != "gopher" {
+		t.Errorf("expected the session from X-Session, got %v, %v", session.Values, err)
+	}
+
+	// Other transports are ignored.
+	if session, _ := loadJWT(store, token); !session.IsNew {
+		t.Error("expected the Authorization header to be ignored")
+	}
+}
+
+func TestJWTStore_DefaultTransportRejectsAmbiguousTokens(t *testing.T) {
+	store := NewJWTStore([]byte("test-secret-key"))
+	token := func(user string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		rec := httptest.NewRecorder()
+		session, _ := store.Get(req, "jwt-session")
+		session.Values["user"] = user
+		session.Save(req, rec)
+		return rec.Header().Get("X-JWT-Token")
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token("alice"))
+	req.AddCookie(&http.Cookie{Name: "jwt-session", Value: token("bob")})
+	if _, err := store.Get(req, "jwt-session"); !errors.Is(err, ErrAmbiguousToken) {
+		t.Errorf("expected ErrAmbiguousToken, got %v", err)
+	}
+}
+
+func TestJWTStore_RefreshTransport(t *testing.T) {
+	login := func(store *JWTStore) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		rec := httptest.NewRecorder()
+		session, _ := store.Get(req, "jwt-session")
+		store.Login(session)
+		if err := session.Save(req, rec); err != nil {
+			t.Fatalf("failed to save session: %v", err)
+		}
+		return rec
+	}
+	refreshWith := func(store *JWTStore, req *http.Request) int {
+		rec := httptest.NewRecorder()
+		store.RefreshHandler("jwt-session").ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Cookie only: no refresh header, and headers are not read.
+	store := NewJWTStoreWithRefresh([]byte("test-secret-key"), NewMemoryRefreshStore())
+	store.Transport = CookieTransport{}
+	rec := login(store)
+	if rec.Header().Get("X-Refresh-Token") != "" {
+		t.Error("expected no refresh header with a cookie transport")
+	}
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "jwt-session_refresh" {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("expected an HttpOnly refresh cookie, got %v", rec.Result().Cookies())
+	}
+	req := httptest.NewRequest("POST", "/refresh", nil)
+	req.Header.Set("X-Refresh-Token", cookie.Value)
+	if code := refreshWith(store, req); code != http.StatusUnauthorized {
+		t.Errorf("expected the header to be ignored, got %d", code)
+	}
+	req = httptest.NewRequest("POST", "/refresh", nil)
+	req.AddCookie(cookie)
+	if code := refreshWith(store, req); code != http.StatusOK {
+		t.Errorf("expected the cookie to refresh, got %d", code)
+	}
+
+	// Header only: no refresh cookie.
+	store = NewJWTStoreWithRefresh([]byte("test-secret-key"), NewMemoryRefreshStore())
+	store.Transport = BearerTransport()
+	rec = login(store)
+	if len(rec.Result().Cookies()) != 0 || rec.Header().Get("X-Refresh-Token") == "" {
+		t.Errorf("expected the refresh token in the header only, got %v", rec.Result().Cookies())
+	}
+
+	// An explicit refresh transport wins.
+	store.RefreshTransport = HeaderTransport{Header: "X-Renew", ResponseHeader: "X-Renew"}
+	rec = login(store)
+	req = httptest.NewRequest("POST", "/refresh", nil)
+	req.Header.Set("X-Renew", rec.Header().Get("X-Renew"))
+	if code := refreshWith(store, req); code != http.StatusOK {
+		t.Errorf("expected X-Renew to refresh, got %d", code)
+	}
+}
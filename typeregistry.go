@@ -0,0 +1,216 @@
+package cartsess
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// TypeRegistry maps custom types to the numeric IDs that MessagePack and
+// CBOR serializers write in front of them, so values of those types decode
+// back to the same Go type instead of a generic map. It plays the role of
+// gob.Register, but is explicit and shared by every serializer it is
+// given to.
+type TypeRegistry struct {
+	mutex  sync.RWMutex
+	byType map[reflect.Type]uint8
+	byID   map[uint8]reflect.Type
+}
+
+func NewTypeRegistry() *TypeRegistry {
+	return &TypeRegistry{
+		byType: make(map[reflect.Type]uint8),
+		byID:   make(map[uint8]reflect.Type),
+	}
+}
+
+// Register associates the type of value, a struct or pointer to struct,
+// with id, which must be below 128 and unique within the registry.
+func (r *TypeRegistry) Register(id uint8, value interface{}) error {
+	t := reflect.TypeOf(value)
+	if t == nil || id >= 128 {
+		return fmt.Errorf("cannot register %T with id %d", value, id)
+	}
+	if s := t; s.Kind() != reflect.Struct && (s.Kind() != reflect.Pointer || s.Elem().Kind() != reflect.Struct) {
+		return fmt.Errorf("cannot register %T: not a struct", value)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if other, ok := r.byID[id]; ok && other != t {
+		return fmt.Errorf("id %d already registered for %s", id, other)
+	}
+	r.byType[t] = id
+	r.byID[id] = t
+	return nil
+}
+
+func (r *TypeRegistry) id(t reflect.Type) (uint8, bool) {
+	if r == nil {
+		return 0, false
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	id, ok := r.byType[t]
+	return id, ok
+}
+
+func (r *TypeRegistry) typ(id uint8) (reflect.Type, bool) {
+	if r == nil {
+		return nil, false
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	t, ok := r.byID[id]
+	return t, ok
+}
+
+// construct builds a value of the type registered under id from the
+// decoded field map.
+func (r *TypeRegistry) construct(id uint8, fields interface{}) (interface{}, error) {
+	t, ok := r.typ(id)
+	if !ok {
+		return nil, fmt.Errorf("type id %d is not registered", id)
+	}
+	v := reflect.New(t).Elem()
+	if err := assign(v, fields); err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+// structFields returns the exported fields of a struct value in
+// declaration order, the way the binary serializers encode them.
+func structFields(v reflect.Value) (names []string, values []reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.IsExported() {
+			names = append(names, f.Name)
+			values = append(values, v.Field(i))
+		}
+	}
+	return names, values
+}
+
+// sortedMapKeys returns the keys of a map value, sorted when they are
+// strings so encodings are deterministic.
+func sortedMapKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	if v.Type().Key().Kind() == reflect.String {
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	}
+	return keys
+}
+
+// intValue returns i as an int when it fits, so decoded integers have the
+// type handlers most likely stored.
+func intValue(i int64) interface{} {
+	if int64(int(i)) == i {
+		return int(i)
+	}
+	return i
+}
+
+// assign stores a decoded generic value into dst, converting between
+// numeric kinds and building slices, maps and structs as needed.
+func assign(dst reflect.Value, src interface{}) error {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	sv := reflect.ValueOf(src)
+	if dst.Kind() == reflect.Map && !dst.IsNil() && sv.Kind() == reflect.Map {
+		// Merge into existing maps, such as session.Values.
+		return mergeMap(dst, sv)
+	}
+	if sv.Type().AssignableTo(dst.Type()) {
+		dst.Set(sv)
+		return nil
+	}
+	switch dst.Kind() {
+	case reflect.Pointer:
+		p := reflect.New(dst.Type().Elem())
+		if err := assign(p.Elem(), src); err != nil {
+			return err
+		}
+		dst.Set(p)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		switch sv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			dst.Set(sv.Convert(dst.Type()))
+			return nil
+		}
+	case reflect.String:
+		if sv.Kind() == reflect.String {
+			dst.SetString(sv.String())
+			return nil
+		}
+	case reflect.Slice:
+		if items, ok := src.([]interface{}); ok {
+			s := reflect.MakeSlice(dst.Type(), len(items), len(items))
+			for i, item := range items {
+				if err := assign(s.Index(i), item); err != nil {
+					return err
+				}
+			}
+			dst.Set(s)
+			return nil
+		}
+	case reflect.Array:
+		if items, ok := src.([]interface{}); ok && len(items) == dst.Len() {
+			for i, item := range items {
+				if err := assign(dst.Index(i), item); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if sv.Kind() == reflect.Map {
+			m := reflect.MakeMapWithSize(dst.Type(), sv.Len())
+			if err := mergeMap(m, sv); err != nil {
+				return err
+			}
+			dst.Set(m)
+			return nil
+		}
+	case reflect.Struct:
+		if fields, ok := src.(map[string]interface{}); ok {
+			for name, val := range fields {
+				f := dst.FieldByName(name)
+				if !f.IsValid() || !f.CanSet() {
+					continue
+				}
+				if err := assign(f, val); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot decode %T into %s", src, dst.Type())
+}
+
+// mergeMap stores the entries of the decoded map src into dst.
+func mergeMap(dst, src reflect.Value) error {
+	for _, k := range src.MapKeys() {
+		key := reflect.New(dst.Type().Key()).Elem()
+		if err := assign(key, k.Interface()); err != nil {
+			return err
+		}
+		val := reflect.New(dst.Type().Elem()).Elem()
+		if err := assign(val, src.MapIndex(k).Interface()); err != nil {
+			return err
+		}
+		dst.SetMapIndex(key, val)
+	}
+	return nil
+}
@@ -18,3 +18,11 @@ func generateID(length int) string {
 	}
 	return string(b)
 }
+
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		c[k] = v
+	}
+	return c
+}